@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Category sends matching torrents to their own directory. A torrent
+// matches when every rule that is set matches; the first matching category
+// wins.
+type Category struct {
+	Name string `json:"name" toml:"name"`
+	Dir  string `json:"dir" toml:"dir"`
+	// NamePattern is a regular expression matched against the torrent name,
+	// case-insensitively.
+	NamePattern string `json:"name_pattern" toml:"name_pattern"`
+	// ProviderCategories are the provider's category codes, like apibay's
+	// 201 for movies. A code ending in 00 matches its whole group.
+	ProviderCategories []string `json:"provider_categories" toml:"provider_categories"`
+	MinSizeMB          int64    `json:"min_size_mb" toml:"min_size_mb"`
+	MaxSizeMB          int64    `json:"max_size_mb" toml:"max_size_mb"`
+
+	// namePattern is NamePattern compiled by Validate.
+	namePattern *regexp.Regexp
+}
+
+func (c *Category) Validate() error {
+	if c.Name == "" || c.Dir == "" {
+		return fmt.Errorf("categories need a name and a dir")
+	}
+	re, err := regexp.Compile("(?i)" + c.NamePattern)
+	if err != nil {
+		return fmt.Errorf("category %s: name_pattern: %w", c.Name, err)
+	}
+	c.namePattern = re
+	if c.MaxSizeMB > 0 && c.MaxSizeMB < c.MinSizeMB {
+		return fmt.Errorf("category %s: max_size_mb is below min_size_mb", c.Name)
+	}
+	return nil
+}
+
+// Matches reports whether t matches every rule that is set. The category
+// must have been validated.
+func (c Category) Matches(t Torrent) bool {
+	if c.NamePattern != "" && (c.namePattern == nil || !c.namePattern.MatchString(t.Name)) {
+		return false
+	}
+	if len(c.ProviderCategories) > 0 {
+		found := false
+		for _, code := range c.ProviderCategories {
+			group, isGroup := strings.CutSuffix(code, "00")
+			if t.Category == code || (isGroup && group != "" && strings.HasPrefix(t.Category, group)) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	mb := t.Bytes / (1024 * 1024)
+	if c.MinSizeMB > 0 && mb < c.MinSizeMB {
+		return false
+	}
+	if c.MaxSizeMB > 0 && mb > c.MaxSizeMB {
+		return false
+	}
+	return true
+}
+
+// matchCategory returns the index of the first category t matches, or -1.
+func (m *model) matchCategory(t Torrent) int {
+	for i, c := range m.settings.Categories {
+		if c.Matches(t) {
+			return i
+		}
+	}
+	return -1
+}
+
+// categoryRoots are the directories downloads can land in: the download
+// root and each category's directory.
+func (m *model) categoryRoots() []string {
+	roots := []string{downloadRoot}
+	for _, c := range m.settings.Categories {
+		roots = append(roots, expandHome(c.Dir))
+	}
+	return roots
+}
+
+// dataRoots are the directories downloads' data may be under: the category
+// roots and where organizing puts files. They only come from the config, so
+// a tampered state file can't widen them.
+func (m *model) dataRoots() []string {
+	return append(m.categoryRoots(), m.settings.Organize.root())
+}
+
+// allowedDestination reports whether a destination typed at the prompt is
+// one of the data roots or inside one.
+func (m *model) allowedDestination(dir string) bool {
+	roots := m.dataRoots()
+	for _, root := range roots {
+		if filepath.Clean(root) == dir {
+			return true
+		}
+	}
+	return ensureInside(dir, roots...) == nil
+}
+
+// destinationPrompt asks where a download should go before it starts. It
+// starts on the directory the category rules picked; up and down step
+// through the categories and the path can be edited by hand, as long as it
+// stays under one of the data roots.
+type destinationPrompt struct {
+	torrent  Torrent
+	field    textinput.Model
+	selected int
+}
+
+func (m *model) promptDestination(t Torrent) {
+	field := textinput.New()
+	field.Width = 60
+	field.Focus()
+
+	m.destination = &destinationPrompt{torrent: t, field: field}
+	m.selectDestination(m.matchCategory(t))
+	m.view = viewDestination
+}
+
+// selectDestination picks category i, or the download root for -1.
+func (m *model) selectDestination(i int) {
+	p := m.destination
+	p.selected = i
+	if i < 0 {
+		p.field.SetValue(downloadRoot)
+	} else {
+		p.field.SetValue(expandHome(m.settings.Categories[i].Dir))
+	}
+	p.field.CursorEnd()
+}
+
+func (m *model) updateDestination(msg tea.KeyMsg) tea.Cmd {
+	p := m.destination
+	switch msg.String() {
+	case "esc":
+		m.destination = nil
+		m.view = viewTorrents
+		return nil
+	case "up":
+		if p.selected >= 0 {
+			m.selectDestination(p.selected - 1)
+		}
+		return nil
+	case "down":
+		if p.selected < len(m.settings.Categories)-1 {
+			m.selectDestination(p.selected + 1)
+		}
+		return nil
+	case "enter":
+		dir := filepath.Clean(expandHome(strings.TrimSpace(p.field.Value())))
+		if !filepath.IsAbs(dir) {
+			m.notice = "The destination must be an absolute path"
+			return nil
+		}
+		if !m.allowedDestination(dir) {
+			m.notice = "The destination must be under the download, a category or the organize directory"
+			return nil
+		}
+		t := p.torrent
+		if dir != downloadRoot {
+			t.Dir = dir
+		}
+		m.destination = nil
+		m.view = viewTorrents
+		return m.startDownload(t)
+	}
+
+	var cmd tea.Cmd
+	p.field, cmd = p.field.Update(msg)
+	return cmd
+}
+
+func (m model) renderDestinationView() string {
+	p := m.destination
+	lines := []string{"Download " + p.torrent.Name + " to:", "", m.styles.InputField.Render(p.field.View()), ""}
+
+	choices := []string{"Default"}
+	for _, c := range m.settings.Categories {
+		choices = append(choices, c.Name)
+	}
+	for i, name := range choices {
+		if i-1 == p.selected {
+			name = m.styles.SelectedRow.Render(name)
+		}
+		lines = append(lines, name)
+	}
+
+	footer := lipgloss.NewStyle().
+		Background(lipgloss.Color("#4c566a")).
+		Foreground(lipgloss.Color("#eceff4")).
+		Padding(0, 1).
+		Render("up/down to pick a category, enter to download, esc to cancel")
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		strings.Join(lines, "\n"),
+		footer,
+	)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestCategoryMatches(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Categories = []Category{
+		{Name: "Shows", Dir: "~/Shows", NamePattern: `S\d+E\d+`},
+		{Name: "Movies", Dir: "~/Movies", ProviderCategories: []string{"200"}, MinSizeMB: 500},
+	}
+	if err := settings.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := New(settings)
+
+	tests := []struct {
+		torrent Torrent
+		want    int
+	}{
+		{Torrent{Name: "Some Show s01e02 1080p"}, 0},
+		{Torrent{Name: "A Movie 2019", Category: "207", Bytes: 2 << 30}, 1},
+		{Torrent{Name: "A Short 2019", Category: "207", Bytes: 100 << 20}, -1},
+		{Torrent{Name: "An Album", Category: "101"}, -1},
+	}
+	for _, tt := range tests {
+		if got := m.matchCategory(tt.torrent); got != tt.want {
+			t.Errorf("matchCategory(%q) = %d, want %d", tt.torrent.Name, got, tt.want)
+		}
+	}
+}
+
+func TestCategoryValidateRejectsBadPattern(t *testing.T) {
+	c := Category{Name: "Broken", Dir: "~/x", NamePattern: "(unclosed"}
+	if err := c.Validate(); err == nil {
+		t.Error("Validate accepted a bad name_pattern")
+	}
+}
+
+func TestDestinationPromptStaysInsideRoots(t *testing.T) {
+	downloads, _ := withPaths(t)
+	shows := filepath.Join(t.TempDir(), "Shows")
+	settings := DefaultSettings()
+	settings.Categories = []Category{{Name: "Shows", Dir: shows}}
+
+	tests := []struct {
+		typed   string
+		started bool
+		dir     string
+	}{
+		{downloads, true, ""},
+		{shows, true, shows},
+		{filepath.Join(shows, "Anime"), true, filepath.Join(shows, "Anime")},
+		{filepath.Join(downloads, "Other") + "/", true, filepath.Join(downloads, "Other")},
+		{"/etc", false, ""},
+		{filepath.Dir(downloads), false, ""},
+		{shows + "-elsewhere", false, ""},
+		{"relative/path", false, ""},
+	}
+	for _, tt := range tests {
+		m := New(settings)
+		m.promptDestination(Torrent{InfoHash: "ABCDEF0123456789", Name: "Show S01E01"})
+		m.destination.field.SetValue(tt.typed)
+		m.updateDestination(tea.KeyMsg{Type: tea.KeyEnter})
+
+		if started := len(m.Downloading) == 1; started != tt.started {
+			t.Errorf("%q: started = %v (notice %q), want %v", tt.typed, started, m.notice, tt.started)
+			continue
+		}
+		if tt.started && m.Downloading[0].Dir != tt.dir {
+			t.Errorf("%q: download dir = %q, want %q", tt.typed, m.Downloading[0].Dir, tt.dir)
+		}
+	}
+}
+
+func TestDataRootsIgnoreStateFile(t *testing.T) {
+	withPaths(t)
+	// A state file claiming a download lives somewhere else must not make
+	// that directory a root.
+	elsewhere := t.TempDir()
+	item := Torrent{InfoHash: "ABCDEF0123456789", Name: "Precious", Dir: elsewhere, DownloadStatus: "Stored"}
+	file := filepath.Join(item.dir(), "keep.txt")
+	writeFiles(t, map[string]int{file: 1})
+	m := New(DefaultSettings())
+	m.Library = []Torrent{item}
+
+	if err := m.removeItem(item.InfoHash, "L", deleteData); err == nil {
+		t.Error("removing an entry pointing outside the data roots was allowed")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("the entry's files outside the data roots were deleted: %v", err)
+	}
+}
@@ -1,80 +1,177 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 var (
-	homeDir, _ = os.UserHomeDir()
-	savePath   = filepath.Join(homeDir, "Downloads", "Sailor", ".downloading.json")
-	saveMutex  sync.Mutex
+	homeDir, _   = os.UserHomeDir()
+	downloadRoot = filepath.Join(homeDir, "Downloads", "Sailor")
+	savePath     = filepath.Join(downloadRoot, ".downloading.json")
+	saveMutex    sync.Mutex
 )
 
-func (m *model) saveDownloadState() error {
-	saveMutex.Lock()
-	defer saveMutex.Unlock()
+const (
+	// stateBackups is how many older copies of the state are kept next to
+	// it as .1 (newest) to .N.
+	stateBackups = 5
+	// backupInterval keeps autosaves from rotating every backup away within
+	// a few seconds of each other.
+	backupInterval = 10 * time.Minute
+)
 
-	err := os.MkdirAll(filepath.Dir(savePath), 0755)
-	if err != nil {
+// replaceFile writes path through a synced temporary file renamed over it,
+// so a crash leaves either the old contents or the new ones.
+func replaceFile(path string, write func(io.Writer) error) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	file, err := os.Create(savePath)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*.tmp")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer os.Remove(tmp.Name())
 
-	allTorrents := append(m.Downloading, m.Library...)
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rotateBackups shifts the backups of path down by one, making room for a
+// new newest one, unless that backup is still recent. It reports whether the
+// caller should write the backup.
+func rotateBackups(path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	if info, err := os.Stat(backupPath(path, 1)); err == nil && time.Since(info.ModTime()) < backupInterval {
+		return false
+	}
+
+	os.Remove(backupPath(path, stateBackups))
+	for n := stateBackups - 1; n >= 1; n-- {
+		os.Rename(backupPath(path, n), backupPath(path, n+1))
+	}
+	return true
+}
+
+func (m *model) stateDocument() (*stateDocument, []byte, error) {
+	doc := &stateDocument{
+		Version:   stateVersion,
+		Downloads: make([]Torrent, len(m.Downloading)),
+		Library:   m.Library,
+	}
+	for i, t := range m.Downloading {
+		t.Reconnect = nil
+		if t.DownloadStatus == "Downloading" && t.Port != 0 {
+			t.Reconnect = &reconnectInfo{Port: t.Port, PGID: t.PGID}
+		}
+		doc.Downloads[i] = t
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, data, nil
+}
 
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(allTorrents)
+func (m *model) saveDownloadState() error {
+	doc, data, err := m.stateDocument()
 	if err != nil {
 		return err
 	}
 
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
+
+	if err := m.store.Save(doc); err != nil {
+		return err
+	}
+	m.lastSaved = data
+
 	log.Println("Download state saved successfully.")
 	return nil
 }
 
-func (m *model) loadDownloadState() error {
+// autosave writes the state if it changed since the last save. It runs on
+// every tick, so changes made close together end up in one write.
+func (m *model) autosave() {
+	doc, data, err := m.stateDocument()
+	if err != nil {
+		log.Printf("Error encoding download state: %v", err)
+		return
+	}
+	if bytes.Equal(data, m.lastSaved) {
+		return
+	}
+
 	saveMutex.Lock()
 	defer saveMutex.Unlock()
 
-	file, err := os.Open(savePath)
-	if os.IsNotExist(err) {
-		log.Println("Download state file does not exist. Starting fresh.")
-		return nil
-	}
-	if err != nil {
-		return err
+	if err := m.store.Save(doc); err != nil {
+		log.Printf("Error autosaving download state: %v", err)
+		return
 	}
-	defer file.Close()
+	m.lastSaved = data
+}
+
+// loadDownloadState reads the state, upgrading older versions. Finished
+// downloads used to stay in the downloads list; they are moved to the
+// library, where they belong.
+func (m *model) loadDownloadState() error {
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
 
-	var allTorrents []Torrent
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&allTorrents)
+	doc, err := m.store.Load()
 	if err != nil {
 		return err
 	}
 
-	allTorrents = removeDuplicateTorrents(allTorrents)
-
 	m.Downloading = nil
-	m.Library = nil
-
-	for _, t := range allTorrents {
-		if t.DownloadStatus == "Downloading" {
-			m.Downloading = append(m.Downloading, t)
-		} else {
-			m.Library = append(m.Library, t)
+	m.Library = removeDuplicateTorrents(doc.Library)
+	for _, t := range removeDuplicateTorrents(doc.Downloads) {
+		if t.DownloadStatus == "Stored" {
+			if m.findLibraryItem(t.InfoHash) == nil {
+				t.Reconnect = nil
+				m.Library = append(m.Library, t)
+			}
+			continue
+		}
+		if t.Reconnect != nil {
+			t.Port, t.PGID = t.Reconnect.Port, t.Reconnect.PGID
 		}
+		m.Downloading = append(m.Downloading, t)
 	}
 
 	log.Println("Download state loaded successfully.")
@@ -86,6 +183,10 @@ func removeDuplicateTorrents(torrents []Torrent) []Torrent {
 	seen := make(map[string]bool)
 	var uniqueTorrents []Torrent
 	for _, t := range torrents {
+		if t.InfoHash == "" {
+			uniqueTorrents = append(uniqueTorrents, t)
+			continue
+		}
 		if !seen[t.InfoHash] {
 			seen[t.InfoHash] = true
 			uniqueTorrents = append(uniqueTorrents, t)
@@ -95,14 +196,5 @@ func removeDuplicateTorrents(torrents []Torrent) []Torrent {
 }
 
 func checkAria2cRPC(port int) error {
-	url := fmt.Sprintf("http://localhost:%d/jsonrpc", port)
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-	return nil
+	return callAria2(port, "aria2.getVersion", nil, nil)
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	capCycleMonthly = "monthly"
+	capCycleRolling = "rolling"
+
+	capActionPause    = "pause"
+	capActionThrottle = "throttle"
+
+	// minCapBytes is the smallest cap that can be set.
+	minCapBytes = 1024 * 1024
+)
+
+// DataCapSettings limits how much is downloaded and uploaded per billing
+// cycle. A cap of 0 is no cap.
+type DataCapSettings struct {
+	DownloadGB float64 `json:"download_gb" toml:"download_gb"`
+	UploadGB   float64 `json:"upload_gb" toml:"upload_gb"`
+	// Cycle is "monthly", resetting on ResetDay, or "rolling", covering the
+	// last RollingDays days.
+	Cycle       string `json:"cycle" toml:"cycle"`
+	ResetDay    int    `json:"reset_day" toml:"reset_day"`
+	RollingDays int    `json:"rolling_days" toml:"rolling_days"`
+	// WarnPercent is how full the cap gets before the status line warns.
+	WarnPercent int `json:"warn_percent" toml:"warn_percent"`
+	// Action is "pause" to pause every download at the cap, or "throttle"
+	// to slow the capped direction down to ThrottleKBps.
+	Action       string `json:"action" toml:"action"`
+	ThrottleKBps int    `json:"throttle_kbps" toml:"throttle_kbps"`
+}
+
+func DefaultDataCapSettings() DataCapSettings {
+	return DataCapSettings{
+		Cycle:        capCycleMonthly,
+		ResetDay:     1,
+		RollingDays:  30,
+		WarnPercent:  80,
+		Action:       capActionPause,
+		ThrottleKBps: 50,
+	}
+}
+
+func (c DataCapSettings) Validate() error {
+	if c.DownloadGB < 0 || c.UploadGB < 0 {
+		return fmt.Errorf("data_cap.download_gb and upload_gb must not be negative")
+	}
+	for _, gb := range []float64{c.DownloadGB, c.UploadGB} {
+		if gb > 0 && gigabytes(gb) < minCapBytes {
+			return fmt.Errorf("data_cap.download_gb and upload_gb must be 0 or at least 1 MiB, got %g", gb)
+		}
+	}
+	switch c.Cycle {
+	case capCycleMonthly, capCycleRolling:
+	default:
+		return fmt.Errorf("data_cap.cycle must be %q or %q, got %q", capCycleMonthly, capCycleRolling, c.Cycle)
+	}
+	if c.ResetDay < 1 || c.ResetDay > 28 {
+		return fmt.Errorf("data_cap.reset_day must be between 1 and 28, got %d", c.ResetDay)
+	}
+	if c.RollingDays < 1 {
+		return fmt.Errorf("data_cap.rolling_days must be at least 1, got %d", c.RollingDays)
+	}
+	if c.WarnPercent < 1 || c.WarnPercent > 100 {
+		return fmt.Errorf("data_cap.warn_percent must be between 1 and 100, got %d", c.WarnPercent)
+	}
+	switch c.Action {
+	case capActionPause, capActionThrottle:
+	default:
+		return fmt.Errorf("data_cap.action must be %q or %q, got %q", capActionPause, capActionThrottle, c.Action)
+	}
+	if c.Action == capActionThrottle && c.ThrottleKBps < 1 {
+		return fmt.Errorf("data_cap.throttle_kbps must be at least 1, got %d", c.ThrottleKBps)
+	}
+	return nil
+}
+
+func (c DataCapSettings) enabled() bool {
+	return c.DownloadGB > 0 || c.UploadGB > 0
+}
+
+// cycleStart is when the billing cycle containing now began.
+func (c DataCapSettings) cycleStart(now time.Time) time.Time {
+	if c.Cycle == capCycleRolling {
+		return now.AddDate(0, 0, -c.RollingDays)
+	}
+	start := time.Date(now.Year(), now.Month(), c.ResetDay, 0, 0, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+func gigabytes(gb float64) int64 {
+	return int64(gb * 1024 * 1024 * 1024)
+}
+
+// dataUsage is what the current cycle has used so far.
+type dataUsage struct {
+	down, up int64
+	loadedAt time.Time
+	// downFull and upFull are set while a cap is reached and enforced.
+	downFull, upFull bool
+}
+
+// percent is how full the fuller of the two caps is.
+func (m *model) capPercent() int {
+	c := m.settings.DataCap
+	percent := 0
+	if limit := gigabytes(c.DownloadGB); limit > 0 {
+		percent = int(m.usage.down * 100 / limit)
+	}
+	if limit := gigabytes(c.UploadGB); limit > 0 {
+		if up := int(m.usage.up * 100 / limit); up > percent {
+			percent = up
+		}
+	}
+	return percent
+}
+
+// loadUsage sums the transfer events of the current cycle, which keeps the
+// count across restarts, and adds what running downloads moved since the
+// last transfer event.
+func (m *model) loadUsage() {
+	events, err := m.store.Events(m.settings.DataCap.cycleStart(time.Now()))
+	if err != nil {
+		log.Printf("Couldn't read transfers for the data cap: %v", err)
+		return
+	}
+
+	var down, up int64
+	for _, e := range events {
+		if e.Kind == eventTransfer {
+			down += e.Bytes
+			up += e.Uploaded
+		}
+	}
+	for _, moved := range m.transfers.moved {
+		down += moved[0]
+		up += moved[1]
+	}
+	m.usage.down, m.usage.up = down, up
+	m.usage.loadedAt = time.Now()
+}
+
+// checkDataCap pauses or throttles downloads once the cycle's cap is
+// reached and lifts that again when a new cycle starts. Like the disk space
+// check, it reapplies itself on every poll so downloads started in the
+// meantime are caught too.
+func (m *model) checkDataCap() {
+	c := m.settings.DataCap
+	if !c.enabled() || m.store == nil || m.readOnly {
+		return
+	}
+	if time.Since(m.usage.loadedAt) >= transferInterval {
+		m.loadUsage()
+	}
+
+	downFull := c.DownloadGB > 0 && m.usage.down >= gigabytes(c.DownloadGB)
+	upFull := c.UploadGB > 0 && m.usage.up >= gigabytes(c.UploadGB)
+	if (downFull || upFull) && !m.usage.downFull && !m.usage.upFull {
+		log.Printf("Data cap reached (%s down, %s up), applying %s",
+			formatBytes(m.usage.down), formatBytes(m.usage.up), c.Action)
+	}
+
+	if c.Action == capActionThrottle {
+		limit := throttleLimit(c.ThrottleKBps, m.runningDownloads())
+		if downFull {
+			m.callRunning("aria2.changeGlobalOption", map[string]string{"max-overall-download-limit": limit})
+		} else if m.usage.downFull {
+			m.callRunning("aria2.changeGlobalOption", map[string]string{"max-overall-download-limit": "0"})
+		}
+		if upFull {
+			m.callRunning("aria2.changeGlobalOption", map[string]string{"max-overall-upload-limit": limit})
+		} else if m.usage.upFull {
+			m.callRunning("aria2.changeGlobalOption", map[string]string{"max-overall-upload-limit": "0"})
+		}
+	} else if downFull || upFull {
+		m.callRunning("aria2.pauseAll")
+	} else if m.usage.downFull || m.usage.upFull {
+		log.Printf("New data cap cycle, resuming downloads")
+		m.unpauseRunning()
+	}
+	m.usage.downFull, m.usage.upFull = downFull, upFull
+}
+
+// throttleLimit splits the throttle between the running downloads, since
+// each has its own aria2c enforcing its own overall limit.
+func throttleLimit(kbps, running int) string {
+	return strconv.Itoa(max(kbps*1024/max(running, 1), 1))
+}
+
+func (m *model) runningDownloads() int {
+	n := 0
+	for _, t := range m.Downloading {
+		if t.DownloadStatus == "Downloading" {
+			n++
+		}
+	}
+	return n
+}
+
+// capPaused reports whether the data cap is holding downloads paused.
+func (m *model) capPaused() bool {
+	return m.settings.DataCap.Action == capActionPause && (m.usage.downFull || m.usage.upFull)
+}
+
+func (m model) renderCapStatus() (string, bool) {
+	c := m.settings.DataCap
+	if !c.enabled() || m.usage.loadedAt.IsZero() {
+		return "", false
+	}
+	percent := m.capPercent()
+	if m.usage.downFull || m.usage.upFull {
+		if c.Action == capActionThrottle {
+			return fmt.Sprintf("Data cap reached (%d%%): throttled to %d KB/s", percent, c.ThrottleKBps), true
+		}
+		return fmt.Sprintf("Data cap reached (%d%%): downloads paused", percent), true
+	}
+	if percent >= c.WarnPercent {
+		return fmt.Sprintf("Data cap %d%% used", percent), false
+	}
+	return "", false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCycleStart(t *testing.T) {
+	day := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name  string
+		cap   DataCapSettings
+		now   time.Time
+		start time.Time
+	}{
+		{"on the reset day", DataCapSettings{ResetDay: 15}, day(2026, 10, 15, 0), day(2026, 10, 15, 0)},
+		{"later on the reset day", DataCapSettings{ResetDay: 15}, day(2026, 10, 15, 23), day(2026, 10, 15, 0)},
+		{"the day before the reset", DataCapSettings{ResetDay: 15}, day(2026, 10, 14, 23), day(2026, 9, 15, 0)},
+		{"first of the month", DataCapSettings{ResetDay: 1}, day(2026, 11, 1, 0), day(2026, 11, 1, 0)},
+		{"end of the month", DataCapSettings{ResetDay: 1}, day(2026, 10, 31, 23), day(2026, 10, 1, 0)},
+		{"across the new year", DataCapSettings{ResetDay: 10}, day(2027, 1, 5, 12), day(2026, 12, 10, 0)},
+		{"after a short February", DataCapSettings{ResetDay: 28}, day(2027, 3, 1, 0), day(2027, 2, 28, 0)},
+		{"before the reset in March", DataCapSettings{ResetDay: 28}, day(2027, 3, 27, 0), day(2027, 2, 28, 0)},
+		{"rolling", DataCapSettings{Cycle: capCycleRolling, RollingDays: 30}, day(2026, 3, 15, 12), day(2026, 2, 13, 12)},
+	}
+	for _, tt := range tests {
+		if tt.cap.Cycle == "" {
+			tt.cap.Cycle = capCycleMonthly
+		}
+		if got := tt.cap.cycleStart(tt.now); !got.Equal(tt.start) {
+			t.Errorf("%s: cycleStart(%v) = %v, want %v", tt.name, tt.now, got, tt.start)
+		}
+	}
+}
+
+func TestDataCapValidateRejectsTinyCaps(t *testing.T) {
+	c := DefaultDataCapSettings()
+	c.DownloadGB = 0.0000001
+	if err := c.Validate(); err == nil {
+		t.Error("a cap under 1 MiB was accepted")
+	}
+	c.DownloadGB = 1.0 / 1024
+	if err := c.Validate(); err != nil {
+		t.Errorf("a 1 MiB cap was refused: %v", err)
+	}
+}
+
+func TestThrottleLimitIsSplit(t *testing.T) {
+	tests := []struct {
+		kbps, running int
+		want          string
+	}{
+		{50, 0, "51200"},
+		{50, 1, "51200"},
+		{50, 4, "12800"},
+		{1, 3000, "1"},
+	}
+	for _, tt := range tests {
+		if got := throttleLimit(tt.kbps, tt.running); got != tt.want {
+			t.Errorf("throttleLimit(%d, %d) = %s, want %s", tt.kbps, tt.running, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+const (
+	preflightRefuse = "refuse"
+	preflightWarn   = "warn"
+)
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem holding path. Missing directories are resolved to their nearest
+// existing parent so it works before the download directory is created.
+func freeSpace(path string) (int64, error) {
+	for {
+		var stat syscall.Statfs_t
+		err := syscall.Statfs(path, &stat)
+		if err == nil {
+			return int64(stat.Bavail) * int64(stat.Bsize), nil
+		}
+		parent := filepath.Dir(path)
+		if !os.IsNotExist(err) || parent == path {
+			return 0, err
+		}
+		path = parent
+	}
+}
+
+// deviceOf returns the device of the filesystem holding path, resolving
+// missing directories the same way as freeSpace.
+func deviceOf(path string) (uint64, error) {
+	for {
+		var stat syscall.Stat_t
+		err := syscall.Stat(path, &stat)
+		if err == nil {
+			return uint64(stat.Dev), nil
+		}
+		parent := filepath.Dir(path)
+		if !os.IsNotExist(err) || parent == path {
+			return 0, err
+		}
+		path = parent
+	}
+}
+
+func formatBytes(n int64) string {
+	return formatSize(strconv.FormatInt(n, 10))
+}
+
+// preflightDiskSpace checks that what is left of t fits on the target
+// filesystem. It returns an error when the download must not start and a
+// warning when it may start but will leave less than the configured minimum
+// free. Downloads being resumed only need room for what isn't on disk yet.
+func (m *model) preflightDiskSpace(t *Torrent) (warning string, err error) {
+	free, err := freeSpace(t.dir())
+	if err != nil {
+		log.Printf("Couldn't check free space for %s: %v", t.Name, err)
+		return "", nil
+	}
+
+	needed := t.Bytes
+	if fileExists(t.dir()) {
+		needed = max(needed-diskUsage(listFiles(t.dir())), 0)
+	}
+
+	minFree := m.settings.DiskSpace.MinFreeBytes()
+	switch {
+	case needed > free:
+		msg := fmt.Sprintf("needs %s more but only %s is free", formatBytes(needed), formatBytes(free))
+		if m.settings.DiskSpace.Preflight == preflightRefuse {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return t.Name + " " + msg, nil
+	case free-needed < minFree:
+		return fmt.Sprintf("%s will leave less than %s free", t.Name, formatBytes(minFree)), nil
+	}
+	return "", nil
+}
+
+// checkDiskSpace pauses the running downloads on a filesystem whose free
+// space drops below the configured minimum and resumes them once it is back
+// above it with a small margin, so they don't flap around the threshold.
+// Downloads may be spread over several filesystems by their categories or
+// destinations, and each one holding a download is checked once.
+func (m *model) checkDiskSpace() {
+	if free, err := freeSpace(downloadRoot); err == nil {
+		m.freeSpace = free
+	} else {
+		log.Printf("Couldn't check free space: %v", err)
+	}
+
+	type filesystem struct {
+		free int64
+		err  error
+	}
+	filesystems := make(map[uint64]filesystem)
+	minFree := m.settings.DiskSpace.MinFreeBytes()
+	m.spacePaused = false
+	for i := range m.Downloading {
+		t := &m.Downloading[i]
+		if t.DownloadStatus != "Downloading" {
+			continue
+		}
+		dev, err := deviceOf(t.dir())
+		if err != nil {
+			log.Printf("Couldn't check free space for %s: %v", t.Name, err)
+			continue
+		}
+		fs, checked := filesystems[dev]
+		if !checked {
+			fs.free, fs.err = freeSpace(t.dir())
+			filesystems[dev] = fs
+			if fs.err != nil {
+				log.Printf("Couldn't check free space for %s: %v", t.Name, fs.err)
+			}
+		}
+		if fs.err != nil {
+			continue
+		}
+
+		switch {
+		case fs.free < minFree:
+			if !t.SpacePaused {
+				log.Printf("Free space for %s is %s, below %s, pausing it", t.Name, formatBytes(fs.free), formatBytes(minFree))
+			}
+			t.SpacePaused = true
+			if err := callAria2(t.Port, "aria2.pauseAll", nil, nil); err != nil {
+				log.Printf("aria2.pauseAll failed for %s: %v", t.Name, err)
+			}
+		case t.SpacePaused && fs.free >= minFree+minFree/10:
+			log.Printf("Free space for %s back to %s, resuming it", t.Name, formatBytes(fs.free))
+			t.SpacePaused = false
+			if !m.capPaused() {
+				if err := callAria2(t.Port, "aria2.unpauseAll", nil, nil); err != nil {
+					log.Printf("aria2.unpauseAll failed for %s: %v", t.Name, err)
+				}
+			}
+		}
+		m.spacePaused = m.spacePaused || t.SpacePaused
+	}
+}
+
+// unpauseRunning resumes the running downloads low disk space isn't
+// holding paused.
+func (m *model) unpauseRunning() {
+	for i := range m.Downloading {
+		t := &m.Downloading[i]
+		if t.DownloadStatus != "Downloading" || t.SpacePaused {
+			continue
+		}
+		if err := callAria2(t.Port, "aria2.unpauseAll", nil, nil); err != nil {
+			log.Printf("aria2.unpauseAll failed for %s: %v", t.Name, err)
+		}
+	}
+}
+
+func (m *model) callRunning(method string, params ...any) {
+	for i := range m.Downloading {
+		t := &m.Downloading[i]
+		if t.DownloadStatus != "Downloading" {
+			continue
+		}
+		if err := callAria2(t.Port, method, params, nil); err != nil {
+			log.Printf("%s failed for %s: %v", method, t.Name, err)
+		}
+	}
+}
+
+type DiskSpaceSettings struct {
+	// Preflight is "refuse" to not start downloads that don't fit, or "warn"
+	// to start them anyway.
+	Preflight      string `json:"preflight" toml:"preflight"`
+	MinFreeSpaceMB int64  `json:"min_free_space_mb" toml:"min_free_space_mb"`
+}
+
+func DefaultDiskSpaceSettings() DiskSpaceSettings {
+	return DiskSpaceSettings{
+		Preflight:      preflightRefuse,
+		MinFreeSpaceMB: 1024,
+	}
+}
+
+func (d DiskSpaceSettings) MinFreeBytes() int64 {
+	return d.MinFreeSpaceMB * 1024 * 1024
+}
+
+func (d DiskSpaceSettings) Validate() error {
+	switch d.Preflight {
+	case preflightRefuse, preflightWarn:
+	default:
+		return fmt.Errorf("disk_space.preflight must be %q or %q, got %q", preflightRefuse, preflightWarn, d.Preflight)
+	}
+	if d.MinFreeSpaceMB < 0 {
+		return fmt.Errorf("disk_space.min_free_space_mb must not be negative")
+	}
+	return nil
+}
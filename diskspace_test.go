@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckDiskSpacePausesDownloadsOnFullFilesystem(t *testing.T) {
+	port, methods := fakeAria2(t)
+	m := New(DefaultSettings())
+	m.settings.DiskSpace.MinFreeSpaceMB = math.MaxInt64 / (1 << 21)
+	m.Downloading = []Torrent{
+		{InfoHash: "A", Name: "Show", DownloadStatus: "Downloading", Dir: t.TempDir(), Port: port},
+		{InfoHash: "B", Name: "Queued", DownloadStatus: "pending", Dir: t.TempDir()},
+	}
+
+	m.checkDiskSpace()
+	if !m.Downloading[0].SpacePaused || !m.spacePaused {
+		t.Fatal("a download on a filesystem below the minimum wasn't paused")
+	}
+	if m.Downloading[1].SpacePaused {
+		t.Error("a download that isn't running was paused")
+	}
+
+	m.settings.DiskSpace.MinFreeSpaceMB = 0
+	m.checkDiskSpace()
+	if m.Downloading[0].SpacePaused || m.spacePaused {
+		t.Error("the download stayed paused with space to spare")
+	}
+	if got := methods(); len(got) != 2 || got[0] != "aria2.pauseAll" || got[1] != "aria2.unpauseAll" {
+		t.Errorf("aria2c got %v, want a pause then an unpause", got)
+	}
+}
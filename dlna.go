@@ -0,0 +1,654 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ssdpMulticast   = "239.255.255.250:1900"
+	mediaServerType = "urn:schemas-upnp-org:device:MediaServer:1"
+	contentDirType  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	connManagerType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	ssdpMaxAge      = 1800
+)
+
+type DLNASettings struct {
+	Enabled      bool   `json:"enabled" toml:"enabled"`
+	FriendlyName string `json:"friendly_name" toml:"friendly_name"`
+	// Interface is the network interface to serve on. Empty picks the first
+	// one that is up, supports multicast and has an IPv4 address.
+	Interface string `json:"interface" toml:"interface"`
+	// Port is where the HTTP side (descriptions, SOAP control, media)
+	// listens on that interface's address.
+	Port int `json:"port" toml:"port"`
+	// SSDPAddr is the multicast group announcements go to. It only needs
+	// changing for testing.
+	SSDPAddr string `json:"ssdp_addr" toml:"ssdp_addr"`
+}
+
+func DefaultDLNASettings() DLNASettings {
+	return DLNASettings{
+		FriendlyName: "Sailor",
+		Port:         8200,
+		SSDPAddr:     ssdpMulticast,
+	}
+}
+
+// dlnaServer is a minimal UPnP MediaServer: SSDP discovery, a
+// ContentDirectory that exposes the library grouped by show and season, a
+// stub ConnectionManager, and HTTP streaming of the files.
+type dlnaServer struct {
+	settings DLNASettings
+	uuid     string
+	ip       net.IP
+	port     int
+	group    *net.UDPAddr
+	ssdp     *net.UDPConn
+	server   *http.Server
+	stop     chan struct{}
+
+	// library is what the content directory serves. The HTTP handlers run
+	// on their own goroutines, so they get a copy instead of the model.
+	// tree is built from it on the first request after it changes.
+	mu      sync.Mutex
+	library []dlnaItem
+	tree    map[string]*contentNode
+}
+
+// dlnaItem is a library entry as far as the content directory cares.
+type dlnaItem struct {
+	infoHash string
+	name     string
+	videos   []string
+}
+
+// lanInterface finds the interface to serve on and its IPv4 address.
+func lanInterface(name string) (*net.Interface, net.IP, error) {
+	var ifaces []net.Interface
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		ifaces = []net.Interface{*iface}
+	} else {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, iface := range all {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+				ifaces = append(ifaces, iface)
+			}
+		}
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				return &iface, ipnet.IP.To4(), nil
+			}
+		}
+	}
+	if name != "" {
+		return nil, nil, fmt.Errorf("%s has no IPv4 address", name)
+	}
+	return nil, nil, fmt.Errorf("no network interface to serve on, set dlna.interface")
+}
+
+func (m *model) startDLNA() (*dlnaServer, error) {
+	settings := m.settings.DLNA
+	iface, ip, err := lanInterface(settings.Interface)
+	if err != nil {
+		return nil, err
+	}
+	group, err := net.ResolveUDPAddr("udp4", settings.SSDPAddr)
+	if err != nil {
+		return nil, err
+	}
+	ssdp, err := net.ListenMulticastUDP("udp4", iface, group)
+	if err != nil {
+		return nil, fmt.Errorf("joining SSDP group on %s: %w", iface.Name, err)
+	}
+	listener, err := net.Listen("tcp4", net.JoinHostPort(ip.String(), strconv.Itoa(settings.Port)))
+	if err != nil {
+		ssdp.Close()
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	sum := sha1.Sum([]byte("sailor-dlna-" + hostname))
+	s := &dlnaServer{
+		settings: settings,
+		uuid:     fmt.Sprintf("uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16]),
+		ip:       ip,
+		port:     listener.Addr().(*net.TCPAddr).Port,
+		group:    group,
+		ssdp:     ssdp,
+		stop:     make(chan struct{}),
+	}
+	s.setLibrary(m.Library)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device.xml", s.serveDeviceDescription)
+	mux.HandleFunc("/ContentDirectory.xml", serveXML(contentDirectorySCPD))
+	mux.HandleFunc("/ConnectionManager.xml", serveXML(connectionManagerSCPD))
+	mux.HandleFunc("/ctl/ContentDirectory", s.serveContentDirectory)
+	mux.HandleFunc("/ctl/ConnectionManager", s.serveConnectionManager)
+	mux.HandleFunc("/media/", s.serveMedia)
+	s.server = &http.Server{Handler: mux}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("DLNA server stopped: %v", err)
+		}
+	}()
+
+	go s.answerSearches()
+	go s.announce()
+
+	log.Printf("DLNA server %s listening on %s", s.uuid, listener.Addr())
+	return s, nil
+}
+
+// Stop says goodbye on SSDP so renderers drop us straight away, then closes
+// both sockets.
+func (s *dlnaServer) Stop() {
+	close(s.stop)
+	for _, nt := range s.notificationTypes() {
+		s.sendNotify(nt, "ssdp:byebye")
+	}
+	s.ssdp.Close()
+	s.server.Close()
+}
+
+// setLibrary replaces what the content directory serves. It is called on
+// every tick, so the content tree is only dropped when something changed.
+func (s *dlnaServer) setLibrary(library []Torrent) {
+	items := make([]dlnaItem, 0, len(library))
+	for _, t := range library {
+		items = append(items, dlnaItem{infoHash: t.InfoHash, name: t.Name, videos: videoFiles(t)})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.EqualFunc(s.library, items, func(a, b dlnaItem) bool {
+		return a.infoHash == b.infoHash && a.name == b.name && slices.Equal(a.videos, b.videos)
+	}) {
+		return
+	}
+	s.library = items
+	s.tree = nil
+}
+
+func (s *dlnaServer) notificationTypes() []string {
+	return []string{"upnp:rootdevice", s.uuid, mediaServerType, contentDirType, connManagerType}
+}
+
+func (s *dlnaServer) usn(nt string) string {
+	if nt == s.uuid {
+		return s.uuid
+	}
+	return s.uuid + "::" + nt
+}
+
+func (s *dlnaServer) location() string {
+	return fmt.Sprintf("http://%s/device.xml", net.JoinHostPort(s.ip.String(), strconv.Itoa(s.port)))
+}
+
+func (s *dlnaServer) announce() {
+	ticker := time.NewTicker(ssdpMaxAge / 2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		for _, nt := range s.notificationTypes() {
+			s.sendNotify(nt, "ssdp:alive")
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendNotify multicasts from the SSDP socket, which sends on the chosen
+// interface.
+func (s *dlnaServer) sendNotify(nt, nts string) {
+	msg := "NOTIFY * HTTP/1.1\r\n" +
+		"HOST: " + s.group.String() + "\r\n" +
+		fmt.Sprintf("CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge) +
+		"LOCATION: " + s.location() + "\r\n" +
+		"NT: " + nt + "\r\n" +
+		"NTS: " + nts + "\r\n" +
+		"SERVER: Linux UPnP/1.0 sailor/1.0\r\n" +
+		"USN: " + s.usn(nt) + "\r\n\r\n"
+
+	if _, err := s.ssdp.WriteToUDP([]byte(msg), s.group); err != nil {
+		log.Printf("SSDP notify failed: %v", err)
+	}
+}
+
+func (s *dlnaServer) answerSearches() {
+	buf := make([]byte, 2048)
+	for {
+		n, remote, err := s.ssdp.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.stop:
+			default:
+				log.Printf("SSDP read failed: %v", err)
+			}
+			return
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+
+		st := req.Header.Get("ST")
+		for _, nt := range s.notificationTypes() {
+			if st != "ssdp:all" && st != nt {
+				continue
+			}
+			resp := "HTTP/1.1 200 OK\r\n" +
+				fmt.Sprintf("CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge) +
+				"DATE: " + time.Now().UTC().Format(http.TimeFormat) + "\r\n" +
+				"EXT:\r\n" +
+				"LOCATION: " + s.location() + "\r\n" +
+				"SERVER: Linux UPnP/1.0 sailor/1.0\r\n" +
+				"ST: " + nt + "\r\n" +
+				"USN: " + s.usn(nt) + "\r\n\r\n"
+			if _, err := s.ssdp.WriteToUDP([]byte(resp), remote); err != nil {
+				log.Printf("SSDP reply to %s failed: %v", remote, err)
+			}
+		}
+	}
+}
+
+func serveXML(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, xml.Header+body)
+	}
+}
+
+func (s *dlnaServer) serveDeviceDescription(w http.ResponseWriter, r *http.Request) {
+	var name bytes.Buffer
+	xml.EscapeText(&name, []byte(s.settings.FriendlyName))
+	serveXML(fmt.Sprintf(deviceDescription, name.String(), s.uuid))(w, r)
+}
+
+// contentNode is one object in the ContentDirectory tree. Containers have
+// children, items have a file.
+type contentNode struct {
+	id       string
+	parentID string
+	title    string
+	children []*contentNode
+	file     string
+	size     int64
+}
+
+// contentTree groups every video in the library into Show/Season folders,
+// with anything that isn't an episode under Movies. The tree is shared
+// between requests and must not be changed.
+func (s *dlnaServer) contentTree() map[string]*contentNode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tree != nil {
+		return s.tree
+	}
+
+	nodes := map[string]*contentNode{"0": {id: "0", parentID: "-1", title: "Sailor"}}
+	container := func(id, parentID, title string) *contentNode {
+		if node, ok := nodes[id]; ok {
+			return node
+		}
+		node := &contentNode{id: id, parentID: parentID, title: title}
+		nodes[id] = node
+		nodes[parentID].children = append(nodes[parentID].children, node)
+		return node
+	}
+
+	for _, t := range s.library {
+		for i, f := range t.videos {
+			info := parseMediaName(filepath.Base(f))
+			if !info.IsEpisode() {
+				fallback := parseMediaName(t.name)
+				if fallback.IsEpisode() {
+					info.Show, info.Season = fallback.Show, fallback.Season
+				}
+			}
+
+			var parent *contentNode
+			if info.Show != "" && info.Season > 0 {
+				show := container("show/"+info.Show, "0", info.Show)
+				parent = container(fmt.Sprintf("%s/%d", show.id, info.Season), show.id, fmt.Sprintf("Season %d", info.Season))
+			} else {
+				parent = container("movies", "0", "Movies")
+			}
+
+			item := &contentNode{
+				id:       fmt.Sprintf("item/%s/%d", t.infoHash, i),
+				parentID: parent.id,
+				title:    strings.TrimSuffix(filepath.Base(f), filepath.Ext(f)),
+				file:     f,
+			}
+			if stat, err := os.Stat(f); err == nil {
+				item.size = stat.Size()
+			}
+			nodes[item.id] = item
+			parent.children = append(parent.children, item)
+		}
+	}
+
+	for _, node := range nodes {
+		sort.Slice(node.children, func(i, j int) bool {
+			return node.children[i].title < node.children[j].title
+		})
+	}
+	s.tree = nodes
+	return nodes
+}
+
+func (s *dlnaServer) didl(nodes []*contentNode, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
+	for _, node := range nodes {
+		title := xmlEscape(node.title)
+		if node.file == "" {
+			fmt.Fprintf(&b, `<container id="%s" parentID="%s" restricted="1" childCount="%d"><dc:title>%s</dc:title><upnp:class>object.container.storageFolder</upnp:class></container>`,
+				xmlEscape(node.id), xmlEscape(node.parentID), len(node.children), title)
+			continue
+		}
+		mediaURL := fmt.Sprintf("http://%s/media/%s", r.Host, url.PathEscape(node.id))
+		fmt.Fprintf(&b, `<item id="%s" parentID="%s" restricted="1"><dc:title>%s</dc:title><upnp:class>object.item.videoItem</upnp:class><res protocolInfo="http-get:*:%s:*" size="%d">%s</res></item>`,
+			xmlEscape(node.id), xmlEscape(node.parentID), title, mimeType(node.file), node.size, xmlEscape(mediaURL))
+	}
+	b.WriteString(`</DIDL-Lite>`)
+	return b.String()
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func mimeType(file string) string {
+	ext := strings.ToLower(filepath.Ext(file))
+	switch ext {
+	case ".mkv":
+		return "video/x-matroska"
+	case ".avi":
+		return "video/x-msvideo"
+	case ".ts":
+		return "video/mp2t"
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+type browseArgs struct {
+	ObjectID       string `xml:"ObjectID"`
+	BrowseFlag     string `xml:"BrowseFlag"`
+	StartingIndex  int    `xml:"StartingIndex"`
+	RequestedCount int    `xml:"RequestedCount"`
+}
+
+// soapAction returns the action named in the SOAPACTION header and decodes
+// its arguments from the envelope body into args.
+func soapAction(r *http.Request, args any) (string, error) {
+	action := strings.Trim(r.Header.Get("SOAPACTION"), `"`)
+	_, name, _ := strings.Cut(action, "#")
+
+	var envelope struct {
+		Body struct {
+			Inner []byte `xml:",innerxml"`
+		} `xml:"Body"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		return name, err
+	}
+	if args != nil {
+		if err := xml.Unmarshal(envelope.Body.Inner, args); err != nil {
+			return name, err
+		}
+	}
+	return name, nil
+}
+
+func writeSOAP(w http.ResponseWriter, service, action string, values [][2]string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><u:%sResponse xmlns:u="%s">`, action, service)
+	for _, v := range values {
+		fmt.Fprintf(&b, "<%s>%s</%s>", v[0], xmlEscape(v[1]), v[0])
+	}
+	fmt.Fprintf(&b, `</u:%sResponse></s:Body></s:Envelope>`, action)
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("EXT", "")
+	fmt.Fprint(w, xml.Header+b.String())
+}
+
+func writeSOAPFault(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, xml.Header+`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`,
+		code, xmlEscape(description))
+}
+
+func (s *dlnaServer) serveContentDirectory(w http.ResponseWriter, r *http.Request) {
+	var args browseArgs
+	action, err := soapAction(r, &args)
+	if err != nil {
+		writeSOAPFault(w, 402, "Invalid Args")
+		return
+	}
+
+	switch action {
+	case "GetSystemUpdateID":
+		writeSOAP(w, contentDirType, action, [][2]string{{"Id", "1"}})
+	case "GetSearchCapabilities":
+		writeSOAP(w, contentDirType, action, [][2]string{{"SearchCaps", ""}})
+	case "GetSortCapabilities":
+		writeSOAP(w, contentDirType, action, [][2]string{{"SortCaps", ""}})
+	case "Browse":
+		nodes := s.contentTree()
+		node, ok := nodes[args.ObjectID]
+		if !ok {
+			writeSOAPFault(w, 701, "No such object")
+			return
+		}
+
+		result := []*contentNode{node}
+		total := 1
+		if args.BrowseFlag == "BrowseDirectChildren" {
+			result, total = node.children, len(node.children)
+			start := min(max(args.StartingIndex, 0), len(result))
+			end := len(result)
+			if args.RequestedCount > 0 {
+				end = min(start+args.RequestedCount, end)
+			}
+			result = result[start:end]
+		}
+
+		writeSOAP(w, contentDirType, action, [][2]string{
+			{"Result", s.didl(result, r)},
+			{"NumberReturned", strconv.Itoa(len(result))},
+			{"TotalMatches", strconv.Itoa(total)},
+			{"UpdateID", "1"},
+		})
+	default:
+		writeSOAPFault(w, 401, "Invalid Action")
+	}
+}
+
+func (s *dlnaServer) serveConnectionManager(w http.ResponseWriter, r *http.Request) {
+	action, err := soapAction(r, nil)
+	if err != nil {
+		writeSOAPFault(w, 402, "Invalid Args")
+		return
+	}
+
+	switch action {
+	case "GetProtocolInfo":
+		writeSOAP(w, connManagerType, action, [][2]string{
+			{"Source", "http-get:*:video/x-matroska:*,http-get:*:video/mp4:*,http-get:*:video/x-msvideo:*,http-get:*:video/mp2t:*"},
+			{"Sink", ""},
+		})
+	case "GetCurrentConnectionIDs":
+		writeSOAP(w, connManagerType, action, [][2]string{{"ConnectionIDs", "0"}})
+	case "GetCurrentConnectionInfo":
+		writeSOAP(w, connManagerType, action, [][2]string{
+			{"RcsID", "-1"}, {"AVTransportID", "-1"}, {"ProtocolInfo", ""},
+			{"PeerConnectionManager", ""}, {"PeerConnectionID", "-1"},
+			{"Direction", "Output"}, {"Status", "OK"},
+		})
+	default:
+		writeSOAPFault(w, 401, "Invalid Action")
+	}
+}
+
+func (s *dlnaServer) serveMedia(w http.ResponseWriter, r *http.Request) {
+	id, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/media/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	node, ok := s.contentTree()[id]
+	if !ok || node.file == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeType(node.file))
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	w.Header().Set("contentFeatures.dlna.org", "DLNA.ORG_OP=01;DLNA.ORG_CI=0")
+	http.ServeFile(w, r, node.file)
+}
+
+const deviceDescription = `<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>urn:schemas-upnp-org:device:MediaServer:1</deviceType>
+<friendlyName>%s</friendlyName>
+<manufacturer>sailor</manufacturer>
+<modelName>sailor</modelName>
+<UDN>%s</UDN>
+<serviceList>
+<service>
+<serviceType>urn:schemas-upnp-org:service:ContentDirectory:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:ContentDirectory</serviceId>
+<SCPDURL>/ContentDirectory.xml</SCPDURL>
+<controlURL>/ctl/ContentDirectory</controlURL>
+<eventSubURL>/evt/ContentDirectory</eventSubURL>
+</service>
+<service>
+<serviceType>urn:schemas-upnp-org:service:ConnectionManager:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:ConnectionManager</serviceId>
+<SCPDURL>/ConnectionManager.xml</SCPDURL>
+<controlURL>/ctl/ConnectionManager</controlURL>
+<eventSubURL>/evt/ConnectionManager</eventSubURL>
+</service>
+</serviceList>
+</device>
+</root>`
+
+const contentDirectorySCPD = `<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<actionList>
+<action><name>Browse</name><argumentList>
+<argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+<argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+<argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+<argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+<argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+<argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+<argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSystemUpdateID</name><argumentList>
+<argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSearchCapabilities</name><argumentList>
+<argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSortCapabilities</name><argumentList>
+<argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+</argumentList></action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType><allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+</serviceStateTable>
+</scpd>`
+
+const connectionManagerSCPD = `<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<actionList>
+<action><name>GetProtocolInfo</name><argumentList>
+<argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+<argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetCurrentConnectionIDs</name><argumentList>
+<argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetCurrentConnectionInfo</name><argumentList>
+<argument><name>ConnectionID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+<argument><name>RcsID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_RcsID</relatedStateVariable></argument>
+<argument><name>AVTransportID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_AVTransportID</relatedStateVariable></argument>
+<argument><name>ProtocolInfo</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ProtocolInfo</relatedStateVariable></argument>
+<argument><name>PeerConnectionManager</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionManager</relatedStateVariable></argument>
+<argument><name>PeerConnectionID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+<argument><name>Direction</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Direction</relatedStateVariable></argument>
+<argument><name>Status</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionStatus</relatedStateVariable></argument>
+</argumentList></action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionStatus</name><dataType>string</dataType><allowedValueList><allowedValue>OK</allowedValue><allowedValue>ContentFormatMismatch</allowedValue><allowedValue>InsufficientBandwidth</allowedValue><allowedValue>UnreliableChannel</allowedValue><allowedValue>Unknown</allowedValue></allowedValueList></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionManager</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Direction</name><dataType>string</dataType><allowedValueList><allowedValue>Input</allowedValue><allowedValue>Output</allowedValue></allowedValueList></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_ProtocolInfo</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionID</name><dataType>i4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_AVTransportID</name><dataType>i4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_RcsID</name><dataType>i4</dataType></stateVariable>
+</serviceStateTable>
+</scpd>`
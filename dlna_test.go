@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freeUDPPort finds a port for the SSDP group, so the test doesn't need
+// port 1900.
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// startTestDLNA serves a library with one episode over the loopback
+// interface.
+func startTestDLNA(t *testing.T) (*dlnaServer, int, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	episode := filepath.Join(dir, "Show.S01E02.mkv")
+	content := []byte("not really a video")
+	if err := os.WriteFile(episode, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ssdpPort := freeUDPPort(t)
+	settings := DefaultSettings()
+	settings.DLNA = DLNASettings{
+		Enabled:      true,
+		FriendlyName: "Test & Sailor",
+		Interface:    "lo",
+		SSDPAddr:     fmt.Sprintf("239.255.255.250:%d", ssdpPort),
+	}
+	m := New(settings)
+	m.Library = []Torrent{{InfoHash: "ABC", Name: "Show S01E02", Files: []string{episode}}}
+
+	s, err := m.startDLNA()
+	if err != nil {
+		t.Skipf("can't serve DLNA on the loopback interface: %v", err)
+	}
+	return s, ssdpPort, content
+}
+
+func TestDLNADiscoveryAndBrowse(t *testing.T) {
+	s, ssdpPort, content := startTestDLNA(t)
+	stopped := false
+	defer func() {
+		if !stopped {
+			s.Stop()
+		}
+	}()
+
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: ssdpPort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: " + mediaServerType + "\r\n\r\n"
+	if _, err := conn.Write([]byte(search)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no answer to M-SEARCH: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := resp.Header.Get("ST"); st != mediaServerType {
+		t.Errorf("ST = %q, want %q", st, mediaServerType)
+	}
+	location := resp.Header.Get("LOCATION")
+	if !strings.HasPrefix(location, "http://127.0.0.1:") {
+		t.Fatalf("LOCATION = %q, want the loopback address", location)
+	}
+
+	description := get(t, location)
+	if !strings.Contains(string(description), "<friendlyName>Test &amp; Sailor</friendlyName>") {
+		t.Errorf("device description has no friendly name:\n%s", description)
+	}
+	base := strings.TrimSuffix(location, "/device.xml")
+	if scpd := get(t, base+"/ConnectionManager.xml"); !bytes.Contains(scpd, []byte("<name>GetCurrentConnectionInfo</name>")) {
+		t.Errorf("ConnectionManager SCPD doesn't list GetCurrentConnectionInfo")
+	}
+
+	// Walk Sailor > Show > Season 1 > the episode.
+	ctl := base + "/ctl/ContentDirectory"
+	id := "0"
+	for _, title := range []string{"Show", "Season 1"} {
+		var found bool
+		for _, c := range browse(t, ctl, id).Containers {
+			if c.Title == title {
+				id, found = c.ID, true
+			}
+		}
+		if !found {
+			t.Fatalf("no %q container under %q", title, id)
+		}
+	}
+	items := browse(t, ctl, id).Items
+	if len(items) != 1 || items[0].Title != "Show.S01E02" {
+		t.Fatalf("season items = %+v, want the one episode", items)
+	}
+	if got := get(t, items[0].Res); !bytes.Equal(got, content) {
+		t.Errorf("media = %q, want %q", got, content)
+	}
+
+	s.Stop()
+	stopped = true
+	if _, err := http.Get(location); err == nil {
+		t.Error("HTTP server still answers after Stop")
+	}
+}
+
+type didlResult struct {
+	Containers []struct {
+		ID    string `xml:"id,attr"`
+		Title string `xml:"title"`
+	} `xml:"container"`
+	Items []struct {
+		ID    string `xml:"id,attr"`
+		Title string `xml:"title"`
+		Res   string `xml:"res"`
+	} `xml:"item"`
+}
+
+func browse(t *testing.T, ctl, id string) didlResult {
+	t.Helper()
+	return browseFrom(t, ctl, id, 0)
+}
+
+func browseFrom(t *testing.T, ctl, id string, start int) didlResult {
+	t.Helper()
+	envelope := `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+		`<u:Browse xmlns:u="` + contentDirType + `"><ObjectID>` + xmlEscape(id) + `</ObjectID>` +
+		`<BrowseFlag>BrowseDirectChildren</BrowseFlag><Filter>*</Filter><StartingIndex>` + strconv.Itoa(start) + `</StartingIndex>` +
+		`<RequestedCount>0</RequestedCount><SortCriteria></SortCriteria></u:Browse></s:Body></s:Envelope>`
+	req, err := http.NewRequest("POST", ctl, strings.NewReader(envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("SOAPACTION", `"`+contentDirType+`#Browse"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var answer struct {
+		Body struct {
+			Response struct {
+				Result string `xml:"Result"`
+			} `xml:"BrowseResponse"`
+		} `xml:"Body"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		t.Fatalf("browsing %q: %v", id, err)
+	}
+	var result didlResult
+	if err := xml.Unmarshal([]byte(answer.Body.Response.Result), &result); err != nil {
+		t.Fatalf("browsing %q: bad DIDL-Lite: %v", id, err)
+	}
+	return result
+}
+
+func get(t *testing.T, url string) []byte {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, resp.Status)
+	}
+	return body
+}
+
+func TestBrowseStartingIndex(t *testing.T) {
+	s := &dlnaServer{}
+	s.setLibrary([]Torrent{
+		{InfoHash: "A", Name: "Alien 1979", Files: []string{"/x/Alien.1979.mkv"}},
+		{InfoHash: "B", Name: "Heat 1995", Files: []string{"/x/Heat.1995.mkv"}},
+	})
+	server := httptest.NewServer(http.HandlerFunc(s.serveContentDirectory))
+	defer server.Close()
+
+	tests := []struct {
+		start int
+		want  int
+	}{
+		{-5, 2},
+		{0, 2},
+		{1, 1},
+		{2, 0},
+		{10, 0},
+	}
+	for _, tt := range tests {
+		if got := browseFrom(t, server.URL, "movies", tt.start).Items; len(got) != tt.want {
+			t.Errorf("browsing from %d returned %d items, want %d", tt.start, len(got), tt.want)
+		}
+	}
+}
+
+func TestContentTreeIsRebuiltOnlyWhenLibraryChanges(t *testing.T) {
+	library := []Torrent{{InfoHash: "A", Name: "Show S01E01", Files: []string{"/x/Show.S01E01.mkv"}}}
+	s := &dlnaServer{}
+	s.setLibrary(library)
+	tree := s.contentTree()
+
+	s.setLibrary(library)
+	if got := s.contentTree(); got["0"] != tree["0"] {
+		t.Error("the content tree was rebuilt for an unchanged library")
+	}
+
+	library = append(library, Torrent{InfoHash: "B", Name: "Show S01E02", Files: []string{"/x/Show.S01E02.mkv"}})
+	s.setLibrary(library)
+	if got := s.contentTree(); got["item/B/0"] == nil {
+		t.Error("the content tree doesn't have the new episode")
+	}
+}
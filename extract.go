@@ -0,0 +1,372 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type ExtractSettings struct {
+	Enabled bool `json:"enabled" toml:"enabled"`
+	// Dest is where archives are extracted to, in a folder per torrent.
+	// Empty means next to the archive.
+	Dest           string `json:"dest" toml:"dest"`
+	DeleteArchives bool   `json:"delete_archives" toml:"delete_archives"`
+	// Unrar and SevenZip are paths to external tools for formats Go can't
+	// read. Empty disables them.
+	Unrar    string `json:"unrar" toml:"unrar"`
+	SevenZip string `json:"seven_zip" toml:"seven_zip"`
+}
+
+var (
+	rarPartPattern   = regexp.MustCompile(`(?i)\.part0*(\d+)\.rar$`)
+	rarVolumePattern = regexp.MustCompile(`(?i)\.(r\d{2,3}|s\d{2})$`)
+	zipVolumePattern = regexp.MustCompile(`(?i)\.z\d{2}$`)
+)
+
+type archive struct {
+	path    string
+	kind    string
+	volumes []string
+	size    int64
+}
+
+// findArchives picks the archives to extract out of files. Only the first
+// volume of a multi-part set is returned, with the rest listed as volumes so
+// they can be deleted together.
+func findArchives(files []string) []archive {
+	var archives []archive
+	for _, f := range files {
+		lower := strings.ToLower(f)
+		a := archive{path: f}
+
+		switch {
+		case rarPartPattern.MatchString(lower):
+			if rarPartPattern.FindStringSubmatch(lower)[1] != "1" {
+				continue
+			}
+			a.kind = "rar"
+			prefix := rarPartPattern.ReplaceAllString(lower, "")
+			for _, v := range files {
+				if l := strings.ToLower(v); rarPartPattern.MatchString(l) && rarPartPattern.ReplaceAllString(l, "") == prefix {
+					a.volumes = append(a.volumes, v)
+				}
+			}
+		case strings.HasSuffix(lower, ".rar"):
+			a.kind = "rar"
+			a.volumes = siblingVolumes(f, files, rarVolumePattern)
+		case strings.HasSuffix(lower, ".zip"):
+			a.kind = "zip"
+			a.volumes = siblingVolumes(f, files, zipVolumePattern)
+		case strings.HasSuffix(lower, ".7z"):
+			a.kind = "7z"
+			a.volumes = []string{f}
+		case strings.HasSuffix(lower, ".tar"):
+			a.kind = "tar"
+			a.volumes = []string{f}
+		case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+			a.kind = "tar.gz"
+			a.volumes = []string{f}
+		case strings.HasSuffix(lower, ".tar.bz2"), strings.HasSuffix(lower, ".tbz2"):
+			a.kind = "tar.bz2"
+			a.volumes = []string{f}
+		default:
+			continue
+		}
+
+		for _, v := range a.volumes {
+			if info, err := os.Stat(v); err == nil {
+				a.size += info.Size()
+			}
+		}
+		archives = append(archives, a)
+	}
+	return archives
+}
+
+// siblingVolumes returns f plus the old-style volumes (name.r00, name.z01,
+// ...) that share its base name.
+func siblingVolumes(f string, files []string, pattern *regexp.Regexp) []string {
+	volumes := []string{f}
+	base := strings.TrimSuffix(f, filepath.Ext(f))
+	for _, v := range files {
+		if pattern.MatchString(v) && strings.TrimSuffix(v, filepath.Ext(v)) == base {
+			volumes = append(volumes, v)
+		}
+	}
+	return volumes
+}
+
+// extractProgressMsg reports how far a download's extraction has got.
+type extractProgressMsg struct {
+	infoHash string
+	percent  int
+}
+
+// extractArchives extracts every archive in t's files, reporting progress
+// to Update and recording what came out on t.ExtractedFiles.
+func (m *model) extractArchives(t *Torrent) {
+	settings := m.settings.Extract
+	archives := findArchives(t.Files)
+	if len(archives) == 0 {
+		return
+	}
+
+	var total, done int64
+	for _, a := range archives {
+		total += a.size
+	}
+	reported := -1
+
+	for _, a := range archives {
+		dest := filepath.Dir(a.path)
+		if settings.Dest != "" {
+			dest = filepath.Join(settings.Dest, sanitizeFileName(t.Name))
+		}
+
+		log.Printf("Extracting %s to %s", a.path, dest)
+		progress := func(n int64) {
+			if percent := int((done + n) * 100 / max(total, 1)); percent != reported {
+				reported = percent
+				m.send(extractProgressMsg{infoHash: t.InfoHash, percent: percent})
+			}
+		}
+
+		files, err := extractArchive(settings, a, dest, progress)
+		t.ExtractedFiles = append(t.ExtractedFiles, files...)
+		done += a.size
+		progress(0)
+		if err != nil {
+			t.LastError = fmt.Sprintf("extracting %s: %v", filepath.Base(a.path), err)
+			log.Println(t.LastError)
+			continue
+		}
+
+		if settings.DeleteArchives {
+			for _, v := range a.volumes {
+				if err := ensureInside(v, t.dir()); err != nil {
+					log.Println(err)
+					continue
+				}
+				if err := os.Remove(v); err != nil {
+					log.Printf("Couldn't delete archive %s: %v", v, err)
+				}
+			}
+		}
+	}
+
+	t.Files = listFiles(t.dir())
+}
+
+func extractArchive(settings ExtractSettings, a archive, dest string, progress func(int64)) ([]string, error) {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return nil, err
+	}
+
+	switch a.kind {
+	case "zip":
+		if len(a.volumes) > 1 {
+			return extractExternal(settings.SevenZip, []string{"x", "-y", "-o" + dest, a.path}, dest, progress)
+		}
+		return extractZip(a.path, dest, progress)
+	case "tar", "tar.gz", "tar.bz2":
+		return extractTar(a, dest, progress)
+	case "rar":
+		if settings.Unrar != "" {
+			return extractExternal(settings.Unrar, []string{"x", "-o+", "-y", a.path, dest + string(filepath.Separator)}, dest, progress)
+		}
+		return extractExternal(settings.SevenZip, []string{"x", "-y", "-o" + dest, a.path}, dest, progress)
+	case "7z":
+		return extractExternal(settings.SevenZip, []string{"x", "-y", "-o" + dest, a.path}, dest, progress)
+	}
+	return nil, fmt.Errorf("unsupported archive %s", a.path)
+}
+
+// extractExternal runs an external extractor and works out which files it
+// produced by comparing dest before and after.
+func extractExternal(tool string, args []string, dest string, progress func(int64)) ([]string, error) {
+	if tool == "" {
+		return nil, fmt.Errorf("no external extractor configured for this format")
+	}
+
+	before := make(map[string]bool)
+	for _, f := range listFiles(dest) {
+		before[f] = true
+	}
+
+	cmd := exec.Command(tool, args...)
+	output := &tailBuffer{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", filepath.Base(tool), err, output.LastLine())
+	}
+
+	var extracted []string
+	for _, f := range listFiles(dest) {
+		if !before[f] {
+			extracted = append(extracted, f)
+		}
+	}
+	return extracted, nil
+}
+
+// safeJoin joins an archive member name onto dest, refusing names that would
+// land outside of it.
+func safeJoin(dest, name string) (string, error) {
+	target := filepath.Join(dest, name)
+	rel, err := filepath.Rel(dest, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %q escapes the destination", name)
+	}
+	return target, nil
+}
+
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	progress func(int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.progress(c.n)
+	return n, err
+}
+
+func extractZip(path, dest string, progress func(int64)) ([]string, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var extracted []string
+	var read int64
+	for _, f := range r.File {
+		target, err := safeJoin(dest, f.Name)
+		if err != nil {
+			return extracted, err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return extracted, err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return extracted, err
+		}
+		offset := read
+		target, err = writeFile(target, &countingReader{r: rc, progress: func(n int64) {
+			progress(offset + n*int64(f.CompressedSize64)/int64(max(f.UncompressedSize64, 1)))
+		}}, f.Mode())
+		rc.Close()
+		if err != nil {
+			return extracted, err
+		}
+		read += int64(f.CompressedSize64)
+		extracted = append(extracted, target)
+	}
+	return extracted, nil
+}
+
+func extractTar(a archive, dest string, progress func(int64)) ([]string, error) {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = &countingReader{r: file, progress: progress}
+	switch a.kind {
+	case "tar.gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case "tar.bz2":
+		r = bzip2.NewReader(r)
+	}
+
+	var extracted []string
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return extracted, nil
+		}
+		if err != nil {
+			return extracted, err
+		}
+
+		target, err := safeJoin(dest, header.Name)
+		if err != nil {
+			return extracted, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return extracted, err
+			}
+		case tar.TypeReg:
+			target, err := writeFile(target, tr, header.FileInfo().Mode())
+			if err != nil {
+				return extracted, err
+			}
+			extracted = append(extracted, target)
+		default:
+			log.Printf("Skipping %s in %s: unsupported entry type", header.Name, a.path)
+		}
+	}
+}
+
+// writeFile writes an archive member to target, or to target with a
+// number before its extension if something is already there, so extracting
+// never overwrites the torrent's own files. It returns the path it wrote.
+func writeFile(target string, r io.Reader, mode os.FileMode) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+
+	ext := filepath.Ext(target)
+	stem := strings.TrimSuffix(target, ext)
+	for n := 1; ; n++ {
+		path := target
+		if n > 1 {
+			path = fmt.Sprintf("%s.%d%s", stem, n, ext)
+		}
+		out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode.Perm()|0600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if path != target {
+			log.Printf("%s already exists, extracting to %s", target, path)
+		}
+
+		if _, err := io.Copy(out, r); err != nil {
+			out.Close()
+			os.Remove(path)
+			return "", err
+		}
+		return path, out.Close()
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	dest := "/downloads/Show"
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"episode.mkv", "/downloads/Show/episode.mkv", true},
+		{"Subs/en.srt", "/downloads/Show/Subs/en.srt", true},
+		{"a/../b.txt", "/downloads/Show/b.txt", true},
+		{"..data", "/downloads/Show/..data", true},
+		{"../escape.txt", "", false},
+		{"Subs/../../escape.txt", "", false},
+		{"..", "", false},
+		{"/etc/passwd", "/downloads/Show/etc/passwd", true},
+	}
+	for _, tt := range tests {
+		got, err := safeJoin(dest, tt.name)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("safeJoin(%q) = %q, %v, want %q (ok %v)", tt.name, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFindArchives(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []archive
+	}{
+		{"no archives", []string{"a.mkv", "a.srt"}, nil},
+		{"zip", []string{"x/a.zip"}, []archive{{path: "x/a.zip", kind: "zip", volumes: []string{"x/a.zip"}}}},
+		{
+			"old-style rar set",
+			[]string{"a.rar", "a.r00", "a.r01", "b.r00", "a.nfo"},
+			[]archive{{path: "a.rar", kind: "rar", volumes: []string{"a.rar", "a.r00", "a.r01"}}},
+		},
+		{
+			"part rar set",
+			[]string{"a.part02.rar", "a.part01.rar", "a.part3.rar", "b.part1.rar"},
+			[]archive{
+				{path: "a.part01.rar", kind: "rar", volumes: []string{"a.part02.rar", "a.part01.rar", "a.part3.rar"}},
+				{path: "b.part1.rar", kind: "rar", volumes: []string{"b.part1.rar"}},
+			},
+		},
+		{
+			"split zip",
+			[]string{"a.z01", "a.zip", "a.z02"},
+			[]archive{{path: "a.zip", kind: "zip", volumes: []string{"a.zip", "a.z01", "a.z02"}}},
+		},
+		{
+			"tarballs",
+			[]string{"a.TAR.GZ", "b.tbz2", "c.tar", "d.7z"},
+			[]archive{
+				{path: "a.TAR.GZ", kind: "tar.gz", volumes: []string{"a.TAR.GZ"}},
+				{path: "b.tbz2", kind: "tar.bz2", volumes: []string{"b.tbz2"}},
+				{path: "c.tar", kind: "tar", volumes: []string{"c.tar"}},
+				{path: "d.7z", kind: "7z", volumes: []string{"d.7z"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findArchives(tt.files); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findArchives(%q) = %+v, want %+v", tt.files, got, tt.want)
+			}
+		})
+	}
+}
+
+func writeZip(t *testing.T, path string, members map[string]string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(file)
+	for name, content := range members {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+}
+
+func TestExtractZipRejectsEscapingEntries(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "dest")
+	path := filepath.Join(dir, "evil.zip")
+	writeZip(t, path, map[string]string{"../escaped.txt": "gotcha"})
+
+	if _, err := extractZip(path, dest, func(int64) {}); err == nil {
+		t.Error("extracting an entry named ../escaped.txt succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.txt")); err == nil {
+		t.Error("the entry was written outside the destination")
+	}
+}
+
+func TestExtractZipKeepsExistingFiles(t *testing.T) {
+	dest := t.TempDir()
+	existing := filepath.Join(dest, "episode.mkv")
+	if err := os.WriteFile(existing, []byte("payload"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dest, "extras.zip")
+	writeZip(t, path, map[string]string{"episode.mkv": "from the archive"})
+
+	files, err := extractZip(path, dest, func(int64) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := filepath.Join(dest, "episode.2.mkv")
+	if len(files) != 1 || files[0] != renamed {
+		t.Errorf("extracted %q, want %q", files, renamed)
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "payload" {
+		t.Errorf("extraction overwrote %s with %q", existing, data)
+	}
+	if data, _ := os.ReadFile(renamed); string(data) != "from the archive" {
+		t.Errorf("%s holds %q", renamed, data)
+	}
+}
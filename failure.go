@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	failureNoPeers    = "no peers"
+	failureDiskFull   = "disk full"
+	failurePermission = "permission"
+	failureNetwork    = "network"
+	failureFileOpen   = "can't open file"
+	failureFileCreate = "can't create file"
+	failureIO         = "I/O error"
+	failureMkdir      = "can't create directory"
+	failureUnknown    = "unknown"
+)
+
+// RetryPolicy decides whether and when a failed download is tried again.
+type RetryPolicy struct {
+	MaxAttempts         int `json:"max_attempts" toml:"max_attempts"`
+	BackoffSeconds      int `json:"backoff_seconds" toml:"backoff_seconds"`
+	MaxBackoffSeconds   int `json:"max_backoff_seconds" toml:"max_backoff_seconds"`
+	StallTimeoutSeconds int `json:"stall_timeout_seconds" toml:"stall_timeout_seconds"`
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:         5,
+		BackoffSeconds:      2,
+		MaxBackoffSeconds:   300,
+		StallTimeoutSeconds: 600,
+	}
+}
+
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("retry.max_attempts must not be negative")
+	}
+	if p.BackoffSeconds < 1 || p.MaxBackoffSeconds < p.BackoffSeconds {
+		return fmt.Errorf("retry backoff must be at least 1s and no more than max_backoff_seconds")
+	}
+	if p.StallTimeoutSeconds < 0 {
+		return fmt.Errorf("retry.stall_timeout_seconds must not be negative")
+	}
+	return nil
+}
+
+// Backoff doubles the base delay for every attempt, capped at the maximum.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	base := time.Duration(p.BackoffSeconds) * time.Second
+	max := time.Duration(p.MaxBackoffSeconds) * time.Second
+
+	d := base << (attempt - 1)
+	if d > max || d <= 0 {
+		return max
+	}
+	return d
+}
+
+// ShouldRetry reports whether another attempt makes sense. Running out of
+// disk, lacking permissions or a path that can't be created won't fix itself
+// by trying again.
+func (p RetryPolicy) ShouldRetry(t *Torrent) bool {
+	switch t.FailureReason {
+	case failureDiskFull, failurePermission, failureFileCreate, failureMkdir:
+		return false
+	}
+	return t.Attempts < p.MaxAttempts
+}
+
+// classifyFailure maps an aria2 error code (which is also aria2c's exit
+// status) and message onto the reason shown to the user.
+func classifyFailure(code int, message string) string {
+	msg := strings.ToLower(message)
+	switch {
+	case code == 9 || strings.Contains(msg, "no space left"):
+		return failureDiskFull
+	case strings.Contains(msg, "permission denied"):
+		return failurePermission
+	}
+
+	switch code {
+	case 2, 3, 5:
+		return failureNoPeers
+	case 6, 19:
+		return failureNetwork
+	case 15:
+		return failureFileOpen
+	case 16:
+		return failureFileCreate
+	case 17:
+		return failureIO
+	case 18:
+		return failureMkdir
+	}
+
+	switch {
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "no peer"):
+		return failureNoPeers
+	case strings.Contains(msg, "network"), strings.Contains(msg, "connection"), strings.Contains(msg, "resolve"):
+		return failureNetwork
+	}
+	return failureUnknown
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		code    int
+		message string
+		want    string
+	}{
+		{9, "", failureDiskFull},
+		{1, "write failed: No space left on device", failureDiskFull},
+		{1, "open: Permission denied", failurePermission},
+		{16, "permission denied", failurePermission},
+		{2, "", failureNoPeers},
+		{3, "resource not found", failureNoPeers},
+		{5, "", failureNoPeers},
+		{6, "", failureNetwork},
+		{19, "", failureNetwork},
+		{15, "", failureFileOpen},
+		{16, "", failureFileCreate},
+		{17, "", failureIO},
+		{18, "", failureMkdir},
+		{-1, "Timeout while waiting", failureNoPeers},
+		{-1, "no peers found", failureNoPeers},
+		{1, "Connection refused", failureNetwork},
+		{1, "could not resolve host", failureNetwork},
+		{1, "something else", failureUnknown},
+		{0, "", failureUnknown},
+	}
+	for _, tt := range tests {
+		if got := classifyFailure(tt.code, tt.message); got != tt.want {
+			t.Errorf("classifyFailure(%d, %q) = %q, want %q", tt.code, tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BackoffSeconds: 2, MaxBackoffSeconds: 30}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 16 * time.Second},
+		{5, 30 * time.Second},
+		{6, 30 * time.Second},
+		{100, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.Backoff(tt.attempt); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3}
+	tests := []struct {
+		reason   string
+		attempts int
+		want     bool
+	}{
+		{failureNoPeers, 0, true},
+		{failureNetwork, 2, true},
+		{failureNetwork, 3, false},
+		{failureUnknown, 0, true},
+		{failureDiskFull, 0, false},
+		{failurePermission, 0, false},
+		{failureFileCreate, 0, false},
+		{failureMkdir, 0, false},
+		{failureIO, 1, true},
+	}
+	for _, tt := range tests {
+		d := &Torrent{FailureReason: tt.reason, Attempts: tt.attempts}
+		if got := p.ShouldRetry(d); got != tt.want {
+			t.Errorf("ShouldRetry(%s after %d attempts) = %v, want %v", tt.reason, tt.attempts, got, tt.want)
+		}
+	}
+}
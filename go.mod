@@ -3,10 +3,14 @@ module Punff/sailor
 go 1.23.1
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/charmbracelet/bubbles v0.20.0
 	github.com/charmbracelet/bubbletea v1.1.1
 	github.com/charmbracelet/lipgloss v0.13.0
 	github.com/evertras/bubble-table v0.17.0
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/sys v0.29.0
+	golang.org/x/text v0.14.0
 )
 
 require (
@@ -24,7 +28,5 @@ require (
 	github.com/muesli/reflow v0.3.0 // indirect
 	github.com/muesli/termenv v0.15.2 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	golang.org/x/sync v0.8.0 // indirect
-	golang.org/x/sys v0.24.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/sync v0.10.0 // indirect
 )
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Hook is a user command run after a download completes. It gets the
+// torrent's details as SAILOR_* environment variables and as JSON on stdin.
+type Hook struct {
+	Name           string `json:"name" toml:"name"`
+	Command        string `json:"command" toml:"command"`
+	TimeoutSeconds int    `json:"timeout_seconds" toml:"timeout_seconds"`
+}
+
+type HookResult struct {
+	Name     string    `json:"name"`
+	OK       bool      `json:"ok"`
+	ExitCode int       `json:"exit_code"`
+	Output   string    `json:"output"`
+	Error    string    `json:"error,omitempty"`
+	Ran      time.Time `json:"ran"`
+}
+
+type hookInput struct {
+	Name     string   `json:"name"`
+	InfoHash string   `json:"info_hash"`
+	Dir      string   `json:"dir"`
+	Files    []string `json:"files"`
+}
+
+func (h Hook) Validate() error {
+	if h.Name == "" || h.Command == "" {
+		return fmt.Errorf("hooks need a name and a command")
+	}
+	if h.TimeoutSeconds < 0 {
+		return fmt.Errorf("hook %q: timeout_seconds must not be negative", h.Name)
+	}
+	return nil
+}
+
+// hookWaitDelay is how long a finished hook's leftover children may hold on
+// to its output.
+const hookWaitDelay = 5 * time.Second
+
+type hookResultMsg struct {
+	infoHash string
+	result   HookResult
+}
+
+func (h Hook) timeout() time.Duration {
+	if h.TimeoutSeconds == 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+// listFiles returns every file under dir, leaving out aria2's own control and
+// session files.
+func listFiles(dir string) []string {
+	var files []string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Error listing %s: %v", path, err)
+			return nil
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".aria2") || d.Name() == ".aria2.session" {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	return files
+}
+
+func runHook(h Hook, input hookInput) HookResult {
+	result := HookResult{Name: h.Name, Ran: time.Now()}
+
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+	defer cancel()
+
+	// The hook gets its own process group so a timeout kills whatever sh
+	// started too. Children left holding stdout would otherwise keep Wait
+	// from returning, so it gives up on the output after hookWaitDelay.
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = hookWaitDelay
+	cmd.Dir = input.Dir
+	cmd.Env = append(os.Environ(),
+		"SAILOR_NAME="+input.Name,
+		"SAILOR_INFO_HASH="+input.InfoHash,
+		"SAILOR_DIR="+input.Dir,
+		"SAILOR_FILES="+strings.Join(input.Files, "\n"),
+	)
+	cmd.Stdin = bytes.NewReader(stdin)
+	output := &tailBuffer{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err = cmd.Run()
+	result.Output = output.String()
+	result.ExitCode = cmd.ProcessState.ExitCode()
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Error = fmt.Sprintf("timed out after %s", h.timeout())
+	case errors.Is(err, exec.ErrWaitDelay) && result.ExitCode == 0:
+		result.OK = true
+	case err != nil:
+		result.Error = err.Error()
+	default:
+		result.OK = true
+	}
+	return result
+}
+
+// runHooks runs every configured hook for a stored torrent in order. It
+// runs off the UI goroutine, so the results are sent to Update to be
+// recorded on the library entry.
+func (m *model) runHooks(t Torrent) {
+	dir := t.Path
+	if dir == "" {
+		dir = t.dir()
+	}
+	input := hookInput{
+		Name:     t.Name,
+		InfoHash: t.InfoHash,
+		Dir:      dir,
+		Files:    t.Files,
+	}
+
+	for _, h := range m.settings.Hooks {
+		result := runHook(h, input)
+		if result.OK {
+			log.Printf("Hook %s for %s succeeded", h.Name, t.Name)
+		} else {
+			log.Printf("Hook %s for %s failed: %s\n%s", h.Name, t.Name, result.Error, result.Output)
+		}
+
+		m.send(hookResultMsg{infoHash: t.InfoHash, result: result})
+	}
+}
+
+// hookDone records a hook's result on the library entry, replacing the one
+// from an earlier run of the same hook.
+func (m *model) hookDone(msg hookResultMsg) {
+	item := m.findLibraryItem(msg.infoHash)
+	if item == nil {
+		return
+	}
+	for i, r := range item.HookResults {
+		if r.Name == msg.result.Name {
+			item.HookResults[i] = msg.result
+			return
+		}
+	}
+	item.HookResults = append(item.HookResults, msg.result)
+}
+
+func (m *model) findLibraryItem(infoHash string) *Torrent {
+	for i := range m.Library {
+		if m.Library[i].InfoHash == infoHash {
+			return &m.Library[i]
+		}
+	}
+	return nil
+}
+
+func hookStatusText(t Torrent) string {
+	if len(t.HookResults) == 0 {
+		return "-"
+	}
+
+	var failed []string
+	for _, r := range t.HookResults {
+		if !r.OK {
+			failed = append(failed, r.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return "failed: " + strings.Join(failed, ", ")
+	}
+	return fmt.Sprintf("ok (%d)", len(t.HookResults))
+}
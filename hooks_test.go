@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunHook(t *testing.T) {
+	input := hookInput{Name: "Show S01E01", InfoHash: "ABC", Dir: t.TempDir(), Files: []string{"a.mkv", "b.srt"}}
+	tests := []struct {
+		name    string
+		command string
+		ok      bool
+		code    int
+		output  string
+	}{
+		{"environment", `printf '%s|%s' "$SAILOR_NAME" "$SAILOR_INFO_HASH"`, true, 0, "Show S01E01|ABC"},
+		{"stdin", `cat`, true, 0, `"files":["a.mkv","b.srt"]`},
+		{"working directory", `pwd`, true, 0, filepath.Base(input.Dir)},
+		{"exit code", `echo broken >&2; exit 3`, false, 3, "broken"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runHook(Hook{Name: tt.name, Command: tt.command}, input)
+			if r.OK != tt.ok || r.ExitCode != tt.code {
+				t.Errorf("ok = %v, exit code %d (%s), want %v, %d", r.OK, r.ExitCode, r.Error, tt.ok, tt.code)
+			}
+			if !strings.Contains(r.Output, tt.output) {
+				t.Errorf("output = %q, want it to contain %q", r.Output, tt.output)
+			}
+		})
+	}
+}
+
+// running reports whether pid is a live process, not counting a zombie
+// nobody has reaped yet.
+func running(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+func TestRunHookTimeoutKillsProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+	hook := Hook{Name: "slow", Command: `sleep 60 & echo $! > child.pid; wait`, TimeoutSeconds: 1}
+
+	start := time.Now()
+	r := runHook(hook, hookInput{Dir: dir})
+	if r.OK || !strings.Contains(r.Error, "timed out") {
+		t.Errorf("result = %+v, want a timeout", r)
+	}
+	if took := time.Since(start); took > hookWaitDelay+5*time.Second {
+		t.Errorf("runHook took %s to give up", took)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); running(pid) && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if running(pid) {
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Errorf("the hook's child %d outlived the timeout", pid)
+	}
+}
+
+func TestHookDoneReplacesEarlierRun(t *testing.T) {
+	m := New(DefaultSettings())
+	m.Library = []Torrent{{InfoHash: "A", HookResults: []HookResult{
+		{Name: "notify", OK: false, Error: "exit status 1"},
+		{Name: "move", OK: true},
+	}}}
+
+	m.hookDone(hookResultMsg{infoHash: "A", result: HookResult{Name: "notify", OK: true}})
+	m.hookDone(hookResultMsg{infoHash: "A", result: HookResult{Name: "scan", OK: true}})
+
+	results := m.Library[0].HookResults
+	if len(results) != 3 || !results[0].OK || results[2].Name != "scan" {
+		t.Errorf("hook results = %+v, want notify replaced and scan added", results)
+	}
+	if got := hookStatusText(m.Library[0]); got != "ok (3)" {
+		t.Errorf("hook status = %q, want ok (3)", got)
+	}
+}
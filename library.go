@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/evertras/bubble-table/table"
+)
+
+var librarySortKeys = []string{"added", "name", "size", "completed"}
+
+// libraryView returns indexes into m.Library in display order, with the
+// unwatched filter and the current sort applied.
+func (m *model) libraryView() []int {
+	var view []int
+	for i, t := range m.Library {
+		if m.unwatchedOnly && t.Watched {
+			continue
+		}
+		view = append(view, i)
+	}
+
+	less := func(a, b Torrent) bool {
+		switch m.librarySort {
+		case "name":
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		case "size":
+			return a.Bytes < b.Bytes
+		case "completed":
+			return a.CompletedAt.Before(b.CompletedAt)
+		default:
+			return a.AddedAt.Before(b.AddedAt)
+		}
+	}
+	sort.SliceStable(view, func(i, j int) bool {
+		a, b := m.Library[view[i]], m.Library[view[j]]
+		if m.librarySortDesc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return view
+}
+
+func (m *model) selectedLibraryItem() *Torrent {
+	view := m.libraryView()
+	if m.selectedID < 0 || m.selectedID >= len(view) {
+		return nil
+	}
+	return &m.Library[view[m.selectedID]]
+}
+
+func (m *model) cycleLibrarySort() {
+	for i, key := range librarySortKeys {
+		if key == m.librarySort || (m.librarySort == "" && key == "added") {
+			m.librarySort = librarySortKeys[(i+1)%len(librarySortKeys)]
+			break
+		}
+	}
+	m.currentPage, m.selectedID = 0, 0
+}
+
+func (m *model) libraryColumns() []table.Column {
+	arrow := " ▲"
+	if m.librarySortDesc {
+		arrow = " ▼"
+	}
+	sortKey := m.librarySort
+	if sortKey == "" {
+		sortKey = "added"
+	}
+
+	columns := CreateLibraryColumns()
+	for i, c := range columns {
+		if c.Key() == sortKey {
+			columns[i] = table.NewColumn(c.Key(), c.Title()+arrow, c.Width())
+		}
+	}
+	return columns
+}
+
+func libraryRowData(torrent Torrent) table.RowData {
+	watched := ""
+	if torrent.Watched {
+		watched = "✓"
+	}
+	return table.RowData{
+		"name":      torrent.Name,
+		"size":      torrent.Size,
+		"added":     formatTime(torrent.AddedAt),
+		"completed": formatTime(torrent.CompletedAt),
+		"watched":   watched,
+		"status":    libraryStatusText(torrent),
+		"hooks":     hookStatusText(torrent),
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func (m *model) renderLibraryDetails() string {
+	t := m.selectedLibraryItem()
+	if t == nil {
+		return ""
+	}
+
+	labelStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#81a1c1")).
+		Bold(true)
+
+	field := func(label, value string) string {
+		if value == "" {
+			value = "-"
+		}
+		return labelStyle.Render(fmt.Sprintf("%-10s", label)) + " " + value
+	}
+
+	lines := []string{
+		field("Name", t.Name),
+		field("Size", t.Size),
+		field("Path", t.Path),
+		field("Info hash", t.InfoHash),
+		field("Magnet", t.Magnet),
+		field("Provider", t.Provider),
+		field("Category", t.Category),
+		field("Query", t.Query),
+		field("Added", formatTime(t.AddedAt)),
+		field("Completed", formatTime(t.CompletedAt)),
+		field("Watched", fmt.Sprintf("%t", t.Watched)),
+		field("Verified", verifyText(t.Verify)),
+		field("Files", fmt.Sprintf("%d", len(t.Files))),
+	}
+	for i, f := range t.Files {
+		if i == 10 {
+			lines = append(lines, fmt.Sprintf("  … %d more", len(t.Files)-i))
+			break
+		}
+		lines = append(lines, "  "+f)
+	}
+
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(m.styles.BorderColor).
+		Padding(0, 1).
+		Width(60).
+		Render(strings.Join(lines, "\n"))
+}
+
+func verifyText(v *VerifyResult) string {
+	if v == nil {
+		return "never"
+	}
+	return fmt.Sprintf("%.1f%% of %d pieces good (%s)", v.Percent(), v.Total, formatTime(v.At))
+}
+
+// renamePrompt edits the name a library entry is shown under.
+type renamePrompt struct {
+	infoHash string
+	field    textinput.Model
+}
+
+func (m *model) promptRename(t Torrent) {
+	field := textinput.New()
+	field.Width = 60
+	field.SetValue(t.Name)
+	field.CursorEnd()
+	field.Focus()
+
+	m.rename = &renamePrompt{infoHash: t.InfoHash, field: field}
+	m.view = viewRename
+}
+
+func (m *model) updateRename(msg tea.KeyMsg) tea.Cmd {
+	p := m.rename
+	switch msg.String() {
+	case "esc":
+		m.rename = nil
+		m.view = viewLibrary
+		return nil
+	case "enter":
+		name := strings.TrimSpace(p.field.Value())
+		if name == "" {
+			m.notice = "The name can't be empty"
+			return nil
+		}
+		m.renameLibraryItem(p.infoHash, name)
+		m.rename = nil
+		m.view = viewLibrary
+		m.UpdateTables()
+		return nil
+	}
+
+	var cmd tea.Cmd
+	p.field, cmd = p.field.Update(msg)
+	return cmd
+}
+
+// renameLibraryItem renames a library entry and journals the old name so
+// the rename can be undone. The files keep their names.
+func (m *model) renameLibraryItem(infoHash, name string) {
+	item := m.findLibraryItem(infoHash)
+	if item == nil || item.Name == name {
+		return
+	}
+	m.journal(undoEntry{Action: journalRename, Name: name, InfoHash: infoHash, OldName: item.Name})
+	item.Name = name
+}
+
+func (m model) renderRenameView() string {
+	footer := lipgloss.NewStyle().
+		Background(lipgloss.Color("#4c566a")).
+		Foreground(lipgloss.Color("#eceff4")).
+		Padding(0, 1).
+		Render("enter to rename, esc to cancel")
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		"Rename to:",
+		"",
+		m.styles.InputField.Render(m.rename.field.View()),
+		"",
+		footer,
+	)
+}
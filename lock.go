@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var lockPath = filepath.Join(downloadRoot, ".lock")
+
+var errLocked = errors.New("state directory is locked")
+
+// lockStateDir takes an advisory lock that is held for as long as sailor
+// runs, so only one instance ever writes the state file. When another
+// instance holds it, the returned pid is that instance's.
+func lockStateDir() (*os.File, int, error) {
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, 0, err
+	}
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		data, _ := os.ReadFile(lockPath)
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, pid, errLocked
+		}
+		return nil, 0, err
+	}
+
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	file.Sync()
+	return file, 0, nil
+}
+
+// askReadOnly explains that another sailor is running and asks whether to
+// open this one read-only instead of exiting.
+func askReadOnly(pid int) bool {
+	owner := "Another sailor"
+	if pid > 0 {
+		owner = fmt.Sprintf("Another sailor (pid %d)", pid)
+	}
+	fmt.Fprintf(os.Stderr, "%s is already using %s.\n", owner, stateDir)
+	fmt.Fprint(os.Stderr, "Open read-only? Changes can't be made and downloads stay with the other instance. [y/N] ")
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// readOnlyKeys are the keys that would change state, per view. They are
+// refused in read-only mode.
+var readOnlyKeys = map[string][]string{
+	viewTorrents:  {"d"},
+	viewDownloads: {"x", "t", "D", "s", "u"},
+	viewLibrary:   {"x", "t", "D", "u", "O", "U", "n", "w", "v", "R"},
+	viewOrganize:  {"enter"},
+}
+
+func (m *model) refusedReadOnly(key string) bool {
+	if !m.readOnly {
+		return false
+	}
+	for _, k := range readOnlyKeys[m.view] {
+		if k == key {
+			m.notice = "Read-only: another sailor owns the state"
+			return true
+		}
+	}
+	return false
+}
+
+// reloadIfChanged picks up the other instance's autosaves while read-only.
+func (m *model) reloadIfChanged() {
+	info, err := os.Stat(m.store.Path())
+	if err != nil || info.ModTime().Equal(m.stateModTime) {
+		return
+	}
+	m.stateModTime = info.ModTime()
+
+	if err := m.loadDownloadState(); err != nil {
+		m.notice = "Couldn't reload state: " + err.Error()
+		return
+	}
+	m.UpdateTables()
+}
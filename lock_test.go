@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestLockStateDirContention(t *testing.T) {
+	withPaths(t)
+	first, _, err := lockStateDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flock locks belong to the open file, so a second open in this process
+	// contends like another instance would.
+	second, pid, err := lockStateDir()
+	if !errors.Is(err, errLocked) || second != nil {
+		t.Fatalf("second lock = %v, %v, want errLocked", second, err)
+	}
+	if pid != os.Getpid() {
+		t.Errorf("lock holder = %d, want this process (%d)", pid, os.Getpid())
+	}
+
+	first.Close()
+	third, _, err := lockStateDir()
+	if err != nil {
+		t.Fatalf("locking after the holder closed: %v", err)
+	}
+	third.Close()
+}
+
+func TestReadOnlyRefusesChanges(t *testing.T) {
+	m := New(DefaultSettings())
+	m.view = viewLibrary
+	if m.refusedReadOnly("x") {
+		t.Error("a writable instance refused a key")
+	}
+
+	m.readOnly = true
+	tests := []struct {
+		view string
+		key  string
+		want bool
+	}{
+		{viewLibrary, "x", true},
+		{viewLibrary, "enter", false},
+		{viewDownloads, "D", true},
+		{viewTorrents, "d", true},
+		{viewTorrents, "/", false},
+		{viewOrganize, "enter", true},
+	}
+	for _, tt := range tests {
+		m.view, m.notice = tt.view, ""
+		if got := m.refusedReadOnly(tt.key); got != tt.want || (m.notice != "") != tt.want {
+			t.Errorf("%s %q refused = %v (notice %q), want %v", tt.view, tt.key, got, m.notice, tt.want)
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
@@ -12,72 +17,139 @@ import (
 )
 
 const (
-	viewSearch    = "search"
-	viewTorrents  = "torrents"
-	viewDownloads = "downloads"
-	viewLibrary   = "library"
+	viewSearch      = "search"
+	viewTorrents    = "torrents"
+	viewDownloads   = "downloads"
+	viewLibrary     = "library"
+	viewOrganize    = "organize"
+	viewChooser     = "chooser"
+	viewDestination = "destination"
+	viewRename      = "rename"
 )
 
 type downloadCreateMsg struct{}
 
+// confirmPrompt is a yes/no question shown in the status line. While one is
+// open every other key is ignored.
+type confirmPrompt struct {
+	text string
+	yes  func() tea.Cmd
+}
+
 type Styles struct {
 	BorderColor lipgloss.Color
 	InputField  lipgloss.Style
 	SelectedRow lipgloss.Style
 }
 
-func DefaultStyles() *Styles {
+// StyleSettings are the colors of the UI, as hex codes or ANSI color
+// numbers.
+type StyleSettings struct {
+	Border             string `json:"border" toml:"border"`
+	InputBorder        string `json:"input_border" toml:"input_border"`
+	SelectedBackground string `json:"selected_background" toml:"selected_background"`
+	SelectedForeground string `json:"selected_foreground" toml:"selected_foreground"`
+}
+
+func DefaultStyleSettings() StyleSettings {
+	return StyleSettings{
+		Border:             "#5e81ac",
+		InputBorder:        "#81a1c1",
+		SelectedBackground: "#88c0d0",
+		SelectedForeground: "#2e3440",
+	}
+}
+
+func NewStyles(s StyleSettings) *Styles {
 	return &Styles{
-		BorderColor: lipgloss.Color("#5e81ac"),
+		BorderColor: lipgloss.Color(s.Border),
 		InputField: lipgloss.NewStyle().
-			BorderForeground(lipgloss.Color("#81a1c1")).
+			BorderForeground(lipgloss.Color(s.InputBorder)).
 			BorderStyle(lipgloss.ThickBorder()).
 			Width(50).
 			Padding(0, 1),
 		SelectedRow: lipgloss.NewStyle().
-			Background(lipgloss.Color("#88c0d0")).
-			Foreground(lipgloss.Color("#2e3440")).
+			Background(lipgloss.Color(s.SelectedBackground)).
+			Foreground(lipgloss.Color(s.SelectedForeground)).
 			Bold(true),
 	}
 }
 
 type model struct {
-	torrents       []Torrent
-	Downloading    []Torrent
-	Library        []Torrent
-	searchField    textinput.Model
-	search         string
-	styles         *Styles
-	view           string
-	err            error
-	torrentTable   table.Model
-	downloadTable  table.Model
-	libraryTable   table.Model
-	currentPage    int
-	rowsPerPage    int
-	selectedID     int
-	width          int
-	height         int
-	downloadStatus bool
-	ticker         *time.Ticker
+	torrents        []Torrent
+	Downloading     []Torrent
+	Library         []Torrent
+	searchField     textinput.Model
+	search          string
+	styles          *Styles
+	view            string
+	err             error
+	torrentTable    table.Model
+	downloadTable   table.Model
+	libraryTable    table.Model
+	currentPage     int
+	rowsPerPage     int
+	selectedID      int
+	width           int
+	height          int
+	downloadStatus  bool
+	ticker          *time.Ticker
+	aria2Err        error
+	settings        *Settings
+	quitting        bool
+	notice          string
+	freeSpace       int64
+	spacePaused     bool
+	organizePlan    []organizeStep
+	librarySort     string
+	librarySortDesc bool
+	unwatchedOnly   bool
+	showDetails     bool
+	chooserItem     string
+	chooserFiles    []string
+	streamServer    *streamServer
+	dlna            *dlnaServer
+	confirm         *confirmPrompt
+	destination     *destinationPrompt
+	rename          *renamePrompt
+	lastSaved       []byte
+	transfers       transferCounters
+	usage           dataUsage
+	stats           *statsReport
+	readOnly        bool
+	store           Store
+	stateModTime    time.Time
+	// program delivers messages from background goroutines.
+	program *tea.Program
+}
+
+// send hands msg to Update from a background goroutine.
+func (m *model) send(msg tea.Msg) {
+	if m.program == nil {
+		log.Printf("Dropping %T: the interface isn't running", msg)
+		return
+	}
+	m.program.Send(msg)
 }
 
-func tick() tea.Cmd {
-	return tea.Tick(2*time.Second, func(_ time.Time) tea.Msg {
+func (m *model) tick() tea.Cmd {
+	return tea.Tick(m.settings.RefreshInterval, func(_ time.Time) tea.Msg {
 		return struct{}{}
 	})
 }
 
-func New() *model {
+func New(settings *Settings) *model {
 	searchField := textinput.New()
 	searchField.Placeholder = "Sail the seas"
+	searchField.ShowSuggestions = true
 	searchField.Focus()
 
 	m := &model{
-		styles:         DefaultStyles(),
+		styles:         NewStyles(settings.Styles),
+		settings:       settings,
 		searchField:    searchField,
 		view:           viewSearch,
-		rowsPerPage:    30,
+		rowsPerPage:    settings.RowsPerPage,
 		downloadStatus: false,
 		currentPage:    0,
 		selectedID:     0,
@@ -109,18 +181,17 @@ func (m *model) CreateDownloadRows() []table.Row {
 			"downloaded": torrent.CompletedSize,
 			"speed":      torrent.DownloadSpeed,
 			"time":       torrent.Time,
+			"status":     m.downloadStatusText(torrent),
 		})
 	}
 	return rows
 }
 
 func (m *model) CreateLibraryRows() []table.Row {
-	rows := make([]table.Row, len(m.Library))
-	for i, torrent := range m.Library {
-		rows[i] = table.NewRow(table.RowData{
-			"name": torrent.Name,
-			"size": torrent.Size,
-		})
+	view := m.libraryView()
+	rows := make([]table.Row, len(view))
+	for i, index := range view {
+		rows[i] = table.NewRow(libraryRowData(m.Library[index]))
 	}
 	return rows
 }
@@ -146,6 +217,7 @@ func CreateDownloadColumns() []table.Column {
 		table.NewColumn("downloaded", "Downloaded", 9),
 		table.NewColumn("speed", "Speed", 9),
 		table.NewColumn("time", "Time", 6),
+		table.NewColumn("status", "Status", 40),
 	}
 }
 
@@ -153,24 +225,57 @@ func CreateLibraryColumns() []table.Column {
 	return []table.Column{
 		table.NewColumn("name", "Name", 50),
 		table.NewColumn("size", "Size", 10),
+		table.NewColumn("added", "Added", 16),
+		table.NewColumn("completed", "Completed", 16),
+		table.NewColumn("watched", "Seen", 4),
+		table.NewColumn("status", "Status", 10),
+		table.NewColumn("hooks", "Hooks", 24),
 	}
 }
 
 func (m *model) UpdateTables() {
 	m.torrentTable = InitTable(CreateTorrentColumns(), m.CreateTorrentRows(m.torrents))
 	m.downloadTable = InitTable(CreateDownloadColumns(), m.CreateDownloadRows())
-	m.libraryTable = InitTable(CreateLibraryColumns(), m.CreateLibraryRows())
+	m.libraryTable = InitTable(m.libraryColumns(), m.CreateLibraryRows())
 }
 
 func (m *model) Init() tea.Cmd {
-	err := m.loadDownloadState()
+	store, err := openStore(m.readOnly)
+	if err != nil {
+		log.Fatalf("Error opening download state: %v", err)
+	}
+	m.store = store
+
+	err = m.loadDownloadState()
 	if err != nil {
 		log.Fatalf("Error loading Download data: %v", err)
 	}
-	go m.GetDownloadInfo()
+	m.loadSearchSuggestions()
+	if m.readOnly {
+		if info, err := os.Stat(m.store.Path()); err == nil {
+			m.stateModTime = info.ModTime()
+		}
+		return m.tick()
+	}
+	if err := m.saveDownloadState(); err != nil {
+		log.Printf("Error saving download state: %v", err)
+	}
+	m.aria2Err = checkAria2c()
+	if m.aria2Err != nil {
+		log.Println(m.aria2Err)
+	}
+	m.resumeDownloads()
+
+	if m.settings.DLNA.Enabled {
+		m.dlna, err = m.startDLNA()
+		if err != nil {
+			log.Printf("Couldn't start DLNA server: %v", err)
+		}
+	}
 
 	return tea.Batch(
-		tick(),
+		m.tick(),
+		m.pollTick(),
 	)
 }
 
@@ -181,12 +286,71 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.WindowSizeMsg:
 		m.SetSize(msg.Width, msg.Height)
 	case downloadCreateMsg:
+		m.startPending()
 		m.view = viewDownloads
 		return m, nil
+	case pollMsg:
+		return m, m.poll()
+	case downloadInfoMsg:
+		m.downloadInfo(msg)
+		return m, nil
+	case aria2cExitedMsg:
+		return m, m.aria2cExited(msg)
+	case retryMsg:
+		m.retry(msg)
+		return m, nil
+	case extractProgressMsg:
+		if t := m.findDownload(msg.infoHash); t != nil && t.DownloadStatus == "Extracting" {
+			t.ExtractProgress = msg.percent
+		}
+		return m, nil
+	case streamStoppedMsg:
+		return m, m.restartForStream(msg)
+	case playbackDoneMsg:
+		cmd := m.playbackDone(msg)
+		m.UpdateTables()
+		return m, cmd
+	case verifyDoneMsg:
+		m.verifyDone(msg)
+		return m, nil
+	case streamReadyMsg:
+		return m, m.streamReady(msg)
+	case processedMsg:
+		cmd := m.finishProcessing(msg.torrent)
+		m.UpdateTables()
+		return m, cmd
+	case hookResultMsg:
+		m.hookDone(msg)
+		m.UpdateTables()
+		return m, nil
+	case shutdownMsg:
+		m.shutdown()
+		return m, tea.Quit
 	case tea.KeyMsg:
+		m.notice = ""
+		if m.confirm != nil && msg.String() != "ctrl+c" {
+			prompt := m.confirm
+			switch msg.String() {
+			case "y", "Y":
+				m.confirm = nil
+				return m, prompt.yes()
+			case "n", "N", "esc":
+				m.confirm = nil
+			}
+			return m, nil
+		}
+		if m.refusedReadOnly(msg.String()) {
+			return m, nil
+		}
+		if m.view == viewDestination && msg.String() != "ctrl+c" {
+			return m, m.updateDestination(msg)
+		}
+		if m.view == viewRename && msg.String() != "ctrl+c" {
+			return m, m.updateRename(msg)
+		}
 		switch msg.String() {
 		case "ctrl+c":
-			m.saveDownloadState()
+			m.shutdown()
 			return m, tea.Quit
 		case "ctrl+d":
 			m.searchField.Blur()
@@ -198,28 +362,172 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.view = viewLibrary
 			m.currentPage, m.selectedID = 0, 0
 			m.UpdateTables()
+		case "ctrl+t":
+			m.searchField.Blur()
+			m.view = viewStats
+			if err := m.loadStats(); err != nil {
+				m.notice = "Couldn't load statistics: " + err.Error()
+			}
 		case "ctrl+s":
 			m.view = viewSearch
 			m.searchField.SetValue("")
 			m.searchField.Focus()
 		case "d":
 			if m.view == viewTorrents {
-				m.torrents[m.selectedID].DownloadStatus = "pending"
-				m.Downloading = append(m.Downloading, m.torrents[m.selectedID])
+				if m.aria2Err != nil {
+					return m, nil
+				}
+				m.promptDestination(m.torrents[m.selectedID])
+				return m, nil
+			}
+		case "x", "t", "D":
+			if m.view == viewDownloads || m.view == viewLibrary {
+				m.removeSelected(map[string]string{"x": keepData, "t": trashData, "D": deleteData}[msg.String()])
+				return m, nil
+			}
+		case "O":
+			if t := m.selectedLibraryItem(); m.view == viewLibrary && t != nil {
+				m.organizePlan = m.planOrganize(*t)
+				m.view = viewOrganize
+				return m, nil
+			}
+		case "r", "e":
+			if m.view == viewStats {
+				var err error
+				if msg.String() == "r" {
+					err = m.loadStats()
+				} else if path, exportErr := m.exportStats(); exportErr == nil {
+					m.notice = "Exported statistics to " + path
+				} else {
+					err = exportErr
+				}
+				if err != nil {
+					m.notice = err.Error()
+				}
+				return m, nil
+			}
+		case "u":
+			if m.view == viewDownloads || m.view == viewLibrary {
+				notice, err := m.undo()
+				if err != nil {
+					m.notice = err.Error()
+				} else {
+					m.notice = notice
+				}
+				if m.aria2Err == nil {
+					m.startPending()
+				}
+				m.UpdateTables()
+				return m, nil
+			}
+		case "U":
+			if m.view == viewLibrary {
+				notice, err := m.undoLastOrganize()
+				if err != nil {
+					m.notice = err.Error()
+				} else {
+					m.notice = notice
+				}
 				m.UpdateTables()
-				return m, m.DownloadTorrents()
+				return m, nil
+			}
+		case "s":
+			if m.view == viewDownloads && m.selectedID < len(m.Downloading) {
+				cmd, err := m.streamDownload(&m.Downloading[m.selectedID])
+				if err != nil {
+					m.notice = "Can't stream: " + err.Error()
+				}
+				return m, cmd
 			}
-		case "x":
-			if m.view == viewDownloads {
-				m.cancelDownload()
+			if m.view == viewLibrary {
+				m.cycleLibrarySort()
+				m.UpdateTables()
 				return m, nil
-			} else if m.view == viewLibrary {
-				m.removeItem(m.Library[m.selectedID].Name, "L")
+			}
+		case "S":
+			if m.view == viewLibrary {
+				m.librarySortDesc = !m.librarySortDesc
+				m.UpdateTables()
+				return m, nil
+			}
+		case "f":
+			if m.view == viewLibrary {
+				m.unwatchedOnly = !m.unwatchedOnly
+				m.currentPage, m.selectedID = 0, 0
+				m.UpdateTables()
+				return m, nil
+			}
+		case "i":
+			if m.view == viewLibrary {
+				m.showDetails = !m.showDetails
+				return m, nil
+			}
+		case "n":
+			if t := m.selectedLibraryItem(); m.view == viewLibrary && t != nil {
+				m.promptRename(*t)
+				return m, nil
+			}
+		case "w":
+			if t := m.selectedLibraryItem(); m.view == viewLibrary && t != nil {
+				t.Watched = !t.Watched
+				m.UpdateTables()
+				return m, nil
+			}
+		case "v":
+			if t := m.selectedLibraryItem(); m.view == viewLibrary && t != nil {
+				m.notice = "Verifying " + t.Name + "…"
+				return m, m.verifyLibraryItem(*t)
+			}
+		case "R":
+			if m.view == viewLibrary {
+				missing, imported, err := m.rescanLibrary()
+				if err != nil {
+					m.notice = err.Error()
+				} else {
+					m.notice = rescanSummary(missing, imported)
+				}
+				m.UpdateTables()
+				return m, nil
+			}
+		case "o":
+			if m.view == viewLibrary {
+				m.openFolder()
+				return m, nil
+			}
+		case "esc":
+			if m.view == viewChooser {
+				m.chooserFiles = nil
+				m.view = viewLibrary
+				m.currentPage, m.selectedID = 0, 0
+				return m, nil
+			}
+			if m.view == viewOrganize {
+				m.organizePlan = nil
+				m.view = viewLibrary
 				return m, nil
 			}
 		case "enter":
+			if m.view == viewLibrary {
+				return m, m.playSelected()
+			}
+			if m.view == viewChooser && len(m.chooserFiles) > 0 {
+				file := m.chooserFiles[m.selectedID]
+				m.chooserFiles = nil
+				m.view = viewLibrary
+				m.currentPage, m.selectedID = 0, 0
+				return m, m.play(m.chooserItem, file)
+			}
+			if m.view == viewOrganize {
+				if err := m.applyOrganize(m.organizePlan); err != nil {
+					m.notice = err.Error()
+				}
+				m.organizePlan = nil
+				m.view = viewLibrary
+				return m, nil
+			}
 			if m.view == viewSearch {
 				m.search = m.searchField.Value()
+				m.recordSearch(m.search)
 				torrents, err := SearchTorrents(m.search)
 				if err != nil {
 					m.err = err
@@ -235,7 +543,15 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.handleNavigation(msg.String())
 		}
 	case struct{}:
-		return m, tick()
+		if m.dlna != nil {
+			m.dlna.setLibrary(m.Library)
+		}
+		if m.readOnly {
+			m.reloadIfChanged()
+		} else if !m.quitting {
+			m.autosave()
+		}
+		return m, m.tick()
 	}
 
 	m.searchField, cmd = m.searchField.Update(msg)
@@ -251,7 +567,9 @@ func (m *model) handleNavigation(key string) {
 	case viewDownloads:
 		length = len(m.Downloading)
 	case viewLibrary:
-		length = len(m.Library)
+		length = len(m.libraryView())
+	case viewChooser:
+		length = len(m.chooserFiles)
 	default:
 		return
 	}
@@ -310,12 +628,56 @@ func (m model) View() string {
 		header = titleStyle.Render("Current Downloads")
 	case viewLibrary:
 		header = titleStyle.Render("Library")
+	case viewOrganize:
+		header = titleStyle.Render("Organize (preview)")
+	case viewChooser:
+		header = titleStyle.Render("Choose a file to play")
+	case viewStats:
+		header = titleStyle.Render("Statistics")
 	default:
 		header = ""
 	}
+	if status := m.renderStatusLine(); status != "" {
+		header = lipgloss.JoinHorizontal(lipgloss.Top, header, "  ", status)
+	}
 	return lipgloss.JoinVertical(lipgloss.Left, header, m.renderContent())
 }
 
+func (m model) renderStatusLine() string {
+	var errorStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#bf616a")).
+		Bold(true)
+
+	var warningStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#ebcb8b"))
+
+	var parts []string
+	if m.readOnly {
+		parts = append(parts, warningStyle.Render("Read-only"))
+	}
+	if m.confirm != nil {
+		parts = append(parts, warningStyle.Render(m.confirm.text+" [y/n]"))
+	}
+	if m.aria2Err != nil {
+		parts = append(parts, errorStyle.Render(m.aria2Err.Error()))
+	}
+	if m.spacePaused {
+		parts = append(parts, errorStyle.Render("Downloads paused: low disk space"))
+	}
+	if status, reached := m.renderCapStatus(); reached {
+		parts = append(parts, errorStyle.Render(status))
+	} else if status != "" {
+		parts = append(parts, warningStyle.Render(status))
+	}
+	if m.notice != "" {
+		parts = append(parts, warningStyle.Render(m.notice))
+	}
+	if m.freeSpace > 0 {
+		parts = append(parts, "Free: "+formatBytes(m.freeSpace))
+	}
+	return strings.Join(parts, "  ")
+}
+
 func (m model) renderContent() string {
 	switch m.view {
 	case viewSearch:
@@ -326,25 +688,33 @@ func (m model) renderContent() string {
 		return m.renderDownloadsView()
 	case viewLibrary:
 		return m.renderLibrary()
+	case viewOrganize:
+		return m.renderOrganizeView()
+	case viewChooser:
+		return m.renderChooserView()
+	case viewDestination:
+		return m.renderDestinationView()
+	case viewRename:
+		return m.renderRenameView()
+	case viewStats:
+		return m.renderStatsView()
 	default:
 		return ""
 	}
 }
 
 func (m *model) renderLibrary() string {
-	start, end := 0, len(m.Library)
-	if end > len(m.Library) {
-		end = len(m.Library)
+	view := m.libraryView()
+	start, end := m.currentPage*m.rowsPerPage, (m.currentPage+1)*m.rowsPerPage
+	if end > len(view) {
+		end = len(view)
 	}
 
-	visibleDownloads := m.Library[start:end]
-	rows := make([]table.Row, len(visibleDownloads))
+	visibleLibrary := view[start:end]
+	rows := make([]table.Row, len(visibleLibrary))
 
-	for i, torrent := range visibleDownloads {
-		row := table.NewRow(table.RowData{
-			"name": torrent.Name,
-			"size": torrent.Size,
-		})
+	for i, index := range visibleLibrary {
+		row := table.NewRow(libraryRowData(m.Library[index]))
 
 		if i+start == m.selectedID {
 			row = row.WithStyle(m.styles.SelectedRow)
@@ -355,17 +725,62 @@ func (m *model) renderLibrary() string {
 
 	tableView := m.libraryTable.WithRows(rows).View()
 
+	filter := "all"
+	if m.unwatchedOnly {
+		filter = "unwatched"
+	}
 	paginationFooter := lipgloss.NewStyle().
 		Background(lipgloss.Color("#4c566a")).
 		Foreground(lipgloss.Color("#eceff4")).
 		Padding(0, 1).
-		Render(fmt.Sprintf("Page %d/%d (Use ←/→ to navigate, ↑/↓ to select)",
-			m.currentPage+1, (len(m.torrents)+m.rowsPerPage-1)/m.rowsPerPage))
+		Render(fmt.Sprintf("Page %d/%d, showing %s (s/S sort, f filter, i details, w watched, n rename, x/t/D remove/trash/delete, u undo)",
+			m.currentPage+1, max((len(view)+m.rowsPerPage-1)/m.rowsPerPage, 1), filter))
 
-	return lipgloss.JoinVertical(lipgloss.Left,
+	content := lipgloss.JoinVertical(lipgloss.Left,
 		tableView,
 		paginationFooter,
 	)
+	if m.showDetails {
+		content = lipgloss.JoinHorizontal(lipgloss.Top, content, " ", m.renderLibraryDetails())
+	}
+	return content
+}
+
+func (m model) renderOrganizeView() string {
+	root := m.settings.Organize.root()
+	rows := make([]table.Row, len(m.organizePlan))
+	for i, step := range m.organizePlan {
+		to, err := filepath.Rel(root, step.To)
+		if err != nil {
+			to = step.To
+		}
+		status := step.Mode
+		if step.Conflict {
+			status = "exists, skip"
+		}
+		rows[i] = table.NewRow(table.RowData{
+			"from":   filepath.Base(step.From),
+			"to":     to,
+			"status": status,
+		})
+	}
+
+	tableView := table.New([]table.Column{
+		table.NewColumn("from", "File", 45),
+		table.NewColumn("to", "Destination", 60),
+		table.NewColumn("status", "Action", 12),
+	}).WithRows(rows).View()
+
+	footer := lipgloss.NewStyle().
+		Background(lipgloss.Color("#4c566a")).
+		Foreground(lipgloss.Color("#eceff4")).
+		Padding(0, 1).
+		Render(fmt.Sprintf("%d files into %s (enter to apply, esc to cancel)", len(m.organizePlan), root))
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		tableView,
+		footer,
+	)
 }
 
 func (m model) renderSearchView() string {
@@ -439,6 +854,7 @@ func (m model) renderDownloadsView() string {
 			"downloaded": torrent.CompletedSize,
 			"speed":      torrent.DownloadSpeed,
 			"time":       torrent.Time,
+			"status":     m.downloadStatusText(torrent),
 		})
 
 		if i+start == m.selectedID {
@@ -469,13 +885,72 @@ func (m *model) SetSize(width, height int) {
 }
 
 func main() {
-	f, err := tea.LogToFile("debug.log", "debug")
+	opts, err := parseOptions(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	setPaths(expandHome(DefaultSettings().DownloadDir), opts.stateDir)
+
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	f, err := tea.LogToFile(logPath, "debug")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer f.Close()
 
-	search := New()
-	app := tea.NewProgram(search, tea.WithAltScreen())
+	if opts.command == "config" {
+		settings, err := loadConfig(opts)
+		os.Exit(printConfig(os.Stdout, opts, settings, err))
+	}
+
+	// Only the instance holding the lock moves or writes anything.
+	readOnly := false
+	lock, pid, err := lockStateDir()
+	if errors.Is(err, errLocked) && opts.command == "undo" {
+		fmt.Fprintln(os.Stderr, "Another sailor is running. Press u there to undo.")
+		os.Exit(1)
+	} else if errors.Is(err, errLocked) {
+		if !askReadOnly(pid) {
+			fmt.Fprintln(os.Stderr, "Exiting. Switch to the running sailor instead.")
+			os.Exit(1)
+		}
+		readOnly = true
+	} else if err != nil {
+		log.Fatalf("Couldn't lock %s: %v", lockPath, err)
+	} else {
+		defer lock.Close()
+	}
+	if !readOnly {
+		migrateLegacyState()
+		if err := seedConfig(opts.configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	settings, err := loadConfig(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	setPaths(expandHome(settings.DownloadDir), opts.stateDir)
+	trackers = settings.Trackers
+
+	search := New(settings)
+	search.readOnly = readOnly
+	if opts.command == "undo" {
+		os.Exit(runUndo(search))
+	}
+
+	app := tea.NewProgram(search, tea.WithAltScreen(), tea.WithoutSignalHandler())
+	search.program = app
+	handleSignals(app)
 	app.Run()
 }
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	organizeMove     = "move"
+	organizeHardlink = "hardlink"
+)
+
+var (
+	episodePattern  = regexp.MustCompile(`(?i)^(.*?)(?:^|[^0-9a-z]+)s(\d{1,2})[ ._-]?e(\d{1,3})(?:[^0-9]|$)`)
+	altEpPattern    = regexp.MustCompile(`(?i)^(.*?)(?:^|[^0-9a-z]+)(\d{1,2})x(\d{2,3})(?:[^0-9]|$)`)
+	yearPattern     = regexp.MustCompile(`(?:^|[^0-9A-Za-z])((?:19|20)\d{2})(?:[^0-9A-Za-z]|$)`)
+	qualityPattern  = regexp.MustCompile(`(?i)(?:^|[^0-9a-z])(480p|576p|720p|1080p|2160p|4k)(?:[^0-9a-z]|$)`)
+	subtitleExts    = map[string]bool{".srt": true, ".ass": true, ".sub": true}
+	organizeLogPath = filepath.Join(downloadRoot, ".organize-undo.jsonl")
+)
+
+type OrganizeSettings struct {
+	// Root is where organized files go. Empty means the download root.
+	Root string `json:"root" toml:"root"`
+	// Template places episodes; {Show}, {SS}, {EE}, {Year}, {Quality} and
+	// {ext} are filled in from the parsed name.
+	Template string `json:"template" toml:"template"`
+	// MovieTemplate places media without a season and episode number, with
+	// {Title} standing in for {Show}.
+	MovieTemplate string `json:"movie_template" toml:"movie_template"`
+	Mode          string `json:"mode" toml:"mode"`
+}
+
+func DefaultOrganizeSettings() OrganizeSettings {
+	return OrganizeSettings{
+		Template:      "{Show}/Season {SS}/{Show} - S{SS}E{EE}.{ext}",
+		MovieTemplate: "{Title} ({Year})/{Title} ({Year}).{ext}",
+		Mode:          organizeMove,
+	}
+}
+
+func (o OrganizeSettings) Validate() error {
+	switch o.Mode {
+	case organizeMove, organizeHardlink:
+	default:
+		return fmt.Errorf("organize.mode must be %q or %q, got %q", organizeMove, organizeHardlink, o.Mode)
+	}
+	if o.Template == "" {
+		return fmt.Errorf("organize.template must not be empty")
+	}
+	return nil
+}
+
+func (o OrganizeSettings) root() string {
+	if o.Root == "" {
+		return downloadRoot
+	}
+	return o.Root
+}
+
+type mediaInfo struct {
+	Show    string
+	Season  int
+	Episode int
+	Year    int
+	Quality string
+}
+
+func (i mediaInfo) IsEpisode() bool {
+	return i.Episode > 0
+}
+
+// parseMediaName pulls show, SxxEyy (or 1x02), year and quality out of a
+// release name like "Top.Gear.S22E03.720p.HDTV.x264".
+func parseMediaName(name string) mediaInfo {
+	var info mediaInfo
+	if ext := strings.ToLower(filepath.Ext(name)); videoExts[ext] || subtitleExts[ext] {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	if match := episodePattern.FindStringSubmatch(name); match != nil {
+		info.Show = cleanTitle(match[1])
+		info.Season, _ = strconv.Atoi(match[2])
+		info.Episode, _ = strconv.Atoi(match[3])
+	} else if match := altEpPattern.FindStringSubmatch(name); match != nil {
+		info.Show = cleanTitle(match[1])
+		info.Season, _ = strconv.Atoi(match[2])
+		info.Episode, _ = strconv.Atoi(match[3])
+	}
+
+	if match := yearPattern.FindStringSubmatchIndex(name); match != nil {
+		info.Year, _ = strconv.Atoi(name[match[2]:match[3]])
+		if info.Show == "" {
+			info.Show = cleanTitle(name[:match[0]])
+		}
+	}
+	if match := qualityPattern.FindStringSubmatch(name); match != nil {
+		info.Quality = strings.ToLower(match[1])
+	}
+	if info.Show == "" {
+		info.Show = cleanTitle(name)
+	}
+	return info
+}
+
+func cleanTitle(s string) string {
+	s = strings.NewReplacer(".", " ", "_", " ").Replace(s)
+	s = strings.Trim(s, " -[(")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func expandTemplate(template string, info mediaInfo, ext string) string {
+	year := ""
+	if info.Year > 0 {
+		year = strconv.Itoa(info.Year)
+	}
+	r := strings.NewReplacer(
+		"{Show}", info.Show,
+		"{Title}", info.Show,
+		"{SS}", fmt.Sprintf("%02d", info.Season),
+		"{EE}", fmt.Sprintf("%02d", info.Episode),
+		"{Year}", year,
+		"{Quality}", info.Quality,
+		"{ext}", strings.TrimPrefix(ext, "."),
+	)
+	path := r.Replace(template)
+	path = strings.ReplaceAll(path, " ()", "")
+	return filepath.Clean(path)
+}
+
+type organizeStep struct {
+	Batch    string    `json:"batch"`
+	Time     time.Time `json:"time"`
+	InfoHash string    `json:"info_hash"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Mode     string    `json:"mode"`
+	Conflict bool      `json:"-"`
+}
+
+// planOrganize works out where each media file of t would go without
+// touching anything, so the plan can be previewed first. A step is a
+// conflict when its target exists or an earlier step already claims it;
+// files are planned largest first so the main episode wins over a sample.
+func (m *model) planOrganize(t Torrent) []organizeStep {
+	settings := m.settings.Organize
+	fallback := parseMediaName(t.Name)
+	batch := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	files := append([]string(nil), t.Files...)
+	sizes := make(map[string]int64)
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			sizes[f] = info.Size()
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return sizes[files[i]] > sizes[files[j]] })
+
+	var plan []organizeStep
+	claimed := make(map[string]bool)
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f))
+		if !videoExts[ext] && !subtitleExts[ext] {
+			continue
+		}
+
+		info := parseMediaName(filepath.Base(f))
+		if !info.IsEpisode() && fallback.IsEpisode() {
+			info = fallback
+		}
+		if info.Year == 0 {
+			info.Year = fallback.Year
+		}
+
+		template := settings.Template
+		if !info.IsEpisode() {
+			template = settings.MovieTemplate
+		}
+		if template == "" {
+			continue
+		}
+
+		to := filepath.Join(settings.root(), expandTemplate(template, info, ext))
+		if to == f {
+			continue
+		}
+		_, err := os.Lstat(to)
+		plan = append(plan, organizeStep{
+			Batch:    batch,
+			InfoHash: t.InfoHash,
+			From:     f,
+			To:       to,
+			Mode:     settings.Mode,
+			Conflict: err == nil || claimed[to],
+		})
+		claimed[to] = true
+	}
+	return plan
+}
+
+// applyOrganize carries out a previewed plan, logging every step so it can
+// be undone, and points the library entry at the new paths.
+func (m *model) applyOrganize(plan []organizeStep) error {
+	logFile, err := os.OpenFile(organizeLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	encoder := json.NewEncoder(logFile)
+
+	applied := 0
+	defer func() {
+		if applied > 0 {
+			entry := undoEntry{Action: journalOrganize, Batch: plan[0].Batch}
+			if item := m.findLibraryItem(plan[0].InfoHash); item != nil {
+				entry.Name = item.Name
+			}
+			m.journal(entry)
+		}
+	}()
+
+	for _, step := range plan {
+		if step.Conflict {
+			log.Printf("Skipping %s: %s already exists", step.From, step.To)
+			continue
+		}
+		if err := ensureInside(step.To, m.settings.Organize.root()); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(step.To), 0755); err != nil {
+			return err
+		}
+
+		if step.Mode == organizeHardlink {
+			err = os.Link(step.From, step.To)
+		} else {
+			err = moveFile(step.From, step.To)
+		}
+		if err != nil {
+			return fmt.Errorf("organizing %s: %w", filepath.Base(step.From), err)
+		}
+
+		step.Time = time.Now()
+		if err := encoder.Encode(step); err != nil {
+			return err
+		}
+		m.updateLibraryPaths(step, false)
+		applied++
+	}
+	return nil
+}
+
+// undoOrganize reverts the most recent organize batch in the undo log.
+func (m *model) undoOrganize() error {
+	steps, err := readOrganizeLog()
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return errors.New("nothing to undo")
+	}
+
+	batch := steps[len(steps)-1].Batch
+	keep := len(steps)
+	for i := len(steps) - 1; i >= 0 && steps[i].Batch == batch; i-- {
+		step := steps[i]
+		err = ensureInside(step.To, m.settings.Organize.root())
+		if err == nil && step.Mode == organizeHardlink {
+			err = os.Remove(step.To)
+		} else if err == nil {
+			if err = ensureInside(step.From, m.dataRoots()...); err == nil {
+				err = moveFile(step.To, step.From)
+			}
+		}
+		if err != nil {
+			if err := writeOrganizeLog(steps[:keep]); err != nil {
+				log.Printf("Couldn't rewrite organize log: %v", err)
+			}
+			return fmt.Errorf("undoing %s: %w", filepath.Base(step.To), err)
+		}
+		m.updateLibraryPaths(step, true)
+		removeEmptyParents(filepath.Dir(step.To), m.settings.Organize.root())
+		keep = i
+	}
+
+	return writeOrganizeLog(steps[:keep])
+}
+
+// updateLibraryPaths keeps a library entry's file list in step with an
+// organize step being applied or undone. Hardlinks leave the original in
+// place, so the link is added or dropped instead of replacing it. Path stays
+// the torrent's own directory; removeData finds moved files through Files.
+func (m *model) updateLibraryPaths(step organizeStep, undo bool) {
+	item := m.findLibraryItem(step.InfoHash)
+	if item == nil {
+		return
+	}
+
+	switch {
+	case step.Mode == organizeHardlink && undo:
+		var files []string
+		for _, f := range item.Files {
+			if f != step.To {
+				files = append(files, f)
+			}
+		}
+		item.Files = files
+	case step.Mode == organizeHardlink:
+		item.Files = append(item.Files, step.To)
+	default:
+		from, to := step.From, step.To
+		if undo {
+			from, to = to, from
+		}
+		for i, f := range item.Files {
+			if f == from {
+				item.Files[i] = to
+			}
+		}
+	}
+}
+
+func readOrganizeLog() ([]organizeStep, error) {
+	file, err := os.Open(organizeLogPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var steps []organizeStep
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var step organizeStep
+		if err := json.Unmarshal(scanner.Bytes(), &step); err != nil {
+			log.Printf("Skipping bad organize log line: %v", err)
+			continue
+		}
+		steps = append(steps, step)
+	}
+	return steps, scanner.Err()
+}
+
+func writeOrganizeLog(steps []organizeStep) error {
+	return replaceFile(organizeLogPath, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for _, step := range steps {
+			if err := encoder.Encode(step); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// moveFile renames from to to, falling back to copy and delete when they
+// are on different filesystems. It never replaces an existing to.
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	err := renameNoReplace(from, to)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
+
+// renameNoReplace is os.Rename that fails when to exists. Filesystems
+// without RENAME_NOREPLACE get a check right before the rename instead.
+func renameNoReplace(from, to string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, from, unix.AT_FDCWD, to, unix.RENAME_NOREPLACE)
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOSYS) {
+		if _, err := os.Lstat(to); err == nil {
+			return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrExist}
+		}
+		return os.Rename(from, to)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+	return nil
+}
+
+// removeEmptyParents removes dir and its parents up to (not including) root
+// while they are empty.
+func removeEmptyParents(dir, root string) {
+	for ensureInside(dir, root) == nil {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMediaName(t *testing.T) {
+	tests := []struct {
+		name string
+		want mediaInfo
+	}{
+		{"Top.Gear.S22E03.720p.HDTV.x264", mediaInfo{Show: "Top Gear", Season: 22, Episode: 3, Quality: "720p"}},
+		{"Show_Name_s01e02.mkv", mediaInfo{Show: "Show Name", Season: 1, Episode: 2}},
+		{"The Office US - S05 E12 [1080p].mkv", mediaInfo{Show: "The Office US", Season: 5, Episode: 12, Quality: "1080p"}},
+		{"The.Office.US.2x05.1080p.mkv", mediaInfo{Show: "The Office US", Season: 2, Episode: 5, Quality: "1080p"}},
+		{"Doctor.Who.2005.S10E01.720p", mediaInfo{Show: "Doctor Who 2005", Season: 10, Episode: 1, Year: 2005, Quality: "720p"}},
+		{"Alien (1979) [2160p].mkv", mediaInfo{Show: "Alien", Year: 1979, Quality: "2160p"}},
+		{"Heat.1995.4K.mp4", mediaInfo{Show: "Heat", Year: 1995, Quality: "4k"}},
+		{"home_video.avi", mediaInfo{Show: "home video"}},
+	}
+	for _, tt := range tests {
+		if got := parseMediaName(tt.name); got != tt.want {
+			t.Errorf("parseMediaName(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]int) {
+	t.Helper()
+	for path, size := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPlanOrganizeConflicts(t *testing.T) {
+	downloads, _ := withPaths(t)
+	root := t.TempDir()
+	dir := filepath.Join(downloads, "Show.S01")
+	episode := filepath.Join(dir, "Show.S01E01.720p.mkv")
+	sample := filepath.Join(dir, "Sample", "Show.S01E01.sample.mkv")
+	second := filepath.Join(dir, "Show.S01E02.720p.mkv")
+	nfo := filepath.Join(dir, "Show.S01.nfo")
+	taken := filepath.Join(root, "Show", "Season 01", "Show - S01E02.mkv")
+	writeFiles(t, map[string]int{sample: 10, episode: 100, second: 100, nfo: 1, taken: 1})
+
+	m := New(DefaultSettings())
+	m.settings.Organize.Root = root
+	plan := m.planOrganize(Torrent{InfoHash: "A", Name: "Show.S01", Files: []string{sample, episode, second, nfo}})
+
+	want := map[string]struct {
+		to       string
+		conflict bool
+	}{
+		episode: {filepath.Join(root, "Show", "Season 01", "Show - S01E01.mkv"), false},
+		sample:  {filepath.Join(root, "Show", "Season 01", "Show - S01E01.mkv"), true},
+		second:  {taken, true},
+	}
+	if len(plan) != len(want) {
+		t.Fatalf("plan = %+v, want %d steps", plan, len(want))
+	}
+	for _, step := range plan {
+		w, ok := want[step.From]
+		if !ok || step.To != w.to || step.Conflict != w.conflict {
+			t.Errorf("step %s -> %s (conflict %v), want -> %s (conflict %v)", step.From, step.To, step.Conflict, w.to, w.conflict)
+		}
+	}
+}
+
+func TestRemovingOrganizedEntryTakesItsFiles(t *testing.T) {
+	downloads, _ := withPaths(t)
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	m := New(DefaultSettings())
+	m.settings.Organize.Root = filepath.Join(downloads, "TV")
+
+	item := Torrent{InfoHash: "A", Name: "Show.S01E01", DownloadStatus: "Stored"}
+	item.Path = item.dir()
+	from := filepath.Join(item.Path, "Show.S01E01.mkv")
+	leftover := filepath.Join(item.Path, "Show.S01E01.nfo")
+	writeFiles(t, map[string]int{from: 100, leftover: 1})
+	item.Files = []string{from, leftover}
+	m.Library = []Torrent{item}
+
+	if err := m.applyOrganize(m.planOrganize(item)); err != nil {
+		t.Fatal(err)
+	}
+	organized := m.Library[0].Files[0]
+	if organized == from {
+		t.Fatal("organizing didn't move the episode")
+	}
+
+	if err := m.removeItem("A", "L", trashData); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{item.Path, organized} {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is still there after removing the entry", path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(m.settings.Organize.Root, "Show")); !os.IsNotExist(err) {
+		t.Error("removing the entry left its empty show directory behind")
+	}
+
+	if _, err := m.undo(); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{leftover, organized} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("undo didn't restore %s: %v", path, err)
+		}
+	}
+	if restored := m.findLibraryItem("A"); restored == nil || restored.Missing {
+		t.Errorf("library entry after undo = %+v", restored)
+	}
+}
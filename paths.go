@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// xdgDir returns the XDG base directory named by env, or its default under
+// the home directory.
+func xdgDir(env string, fallback ...string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(append([]string{homeDir}, fallback...)...)
+}
+
+var (
+	configPath = filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "sailor", "config.toml")
+	stateDir   = filepath.Join(xdgDir("XDG_STATE_HOME", ".local", "state"), "sailor")
+	logPath    string
+)
+
+func expandHome(path string) string {
+	if path == "~" {
+		return homeDir
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homeDir, path[2:])
+	}
+	return path
+}
+
+// setPaths points everything at the configured download and state
+// directories.
+func setPaths(downloads, state string) {
+	downloadRoot = downloads
+	stateDir = state
+
+	savePath = filepath.Join(stateDir, "state.json")
+	dbPath = filepath.Join(stateDir, "sailor.db")
+	lockPath = filepath.Join(stateDir, "lock")
+	organizeLogPath = filepath.Join(stateDir, "organize-undo.jsonl")
+	undoLogPath = filepath.Join(stateDir, "undo.jsonl")
+	logPath = filepath.Join(stateDir, "logs", "sailor.log")
+	settingsPath = filepath.Join(downloadRoot, ".settings.json")
+}
+
+// migrateLegacyState moves state that used to live in hidden files in the
+// download directory over to the state directory.
+func migrateLegacyState() {
+	moves := map[string]string{
+		filepath.Join(downloadRoot, ".downloading.json"):    savePath,
+		filepath.Join(downloadRoot, "sailor.db"):            dbPath,
+		filepath.Join(downloadRoot, ".organize-undo.jsonl"): organizeLogPath,
+	}
+	for n := 1; n <= stateBackups; n++ {
+		moves[backupPath(filepath.Join(downloadRoot, ".downloading.json"), n)] = backupPath(savePath, n)
+		moves[backupPath(filepath.Join(downloadRoot, "sailor.db"), n)] = backupPath(dbPath, n)
+	}
+
+	for from, to := range moves {
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if _, err := os.Stat(to); err == nil {
+			continue
+		}
+		if err := moveFile(from, to); err != nil {
+			log.Printf("Couldn't move %s to %s: %v", from, to, err)
+			continue
+		}
+		log.Printf("Moved %s to %s", from, to)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var videoExts = map[string]bool{
+	".mkv": true, ".mp4": true, ".avi": true, ".m4v": true, ".mov": true,
+	".wmv": true, ".ts": true, ".webm": true, ".mpg": true, ".mpeg": true,
+}
+
+// minPlayback is how long a player has to run for sailor to believe it
+// played the file itself. Launchers like xdg-open hand the file to another
+// program and return at once, so their return says nothing about playback.
+const minPlayback = 10 * time.Second
+
+type PlayerSettings struct {
+	// Player and FileManager are command templates split on whitespace, with
+	// {file} and {dir} replaced in each argument, so paths never go through
+	// a shell.
+	Player      string `json:"player" toml:"player"`
+	FileManager string `json:"file_manager" toml:"file_manager"`
+	// AutoPlayNext plays the next episode when the player exits. It needs a
+	// player that blocks until playback ends, like mpv.
+	AutoPlayNext bool `json:"auto_play_next" toml:"auto_play_next"`
+}
+
+func DefaultPlayerSettings() PlayerSettings {
+	return PlayerSettings{
+		Player:      "xdg-open {file}",
+		FileManager: "xdg-open {dir}",
+	}
+}
+
+func (p PlayerSettings) Validate() error {
+	if len(strings.Fields(p.Player)) == 0 || len(strings.Fields(p.FileManager)) == 0 {
+		return fmt.Errorf("player.player and player.file_manager must not be empty")
+	}
+	return nil
+}
+
+type playbackDoneMsg struct {
+	infoHash string
+	file     string
+	ran      time.Duration
+	err      error
+}
+
+// playerCmd times the player so playbackDone can tell a player that blocked
+// from one that returned straight away.
+type playerCmd struct {
+	*exec.Cmd
+	ran time.Duration
+}
+
+func (c *playerCmd) Run() error {
+	start := time.Now()
+	err := c.Cmd.Run()
+	c.ran = time.Since(start)
+	return err
+}
+
+func (c *playerCmd) SetStdin(r io.Reader) {
+	if c.Stdin == nil {
+		c.Stdin = r
+	}
+}
+
+func (c *playerCmd) SetStdout(w io.Writer) {
+	if c.Stdout == nil {
+		c.Stdout = w
+	}
+}
+
+func (c *playerCmd) SetStderr(w io.Writer) {
+	if c.Stderr == nil {
+		c.Stderr = w
+	}
+}
+
+func commandFromTemplate(template string, vars map[string]string) *exec.Cmd {
+	fields := strings.Fields(template)
+	for i, f := range fields {
+		for k, v := range vars {
+			f = strings.ReplaceAll(f, "{"+k+"}", v)
+		}
+		fields[i] = f
+	}
+	return exec.Command(fields[0], fields[1:]...)
+}
+
+func videoFiles(t Torrent) []string {
+	var videos []string
+	for _, f := range t.Files {
+		if videoExts[strings.ToLower(filepath.Ext(f))] {
+			videos = append(videos, f)
+		}
+	}
+	sort.Strings(videos)
+	return videos
+}
+
+// playSelected plays the selected library item, or lets the user choose when
+// it has more than one video file.
+func (m *model) playSelected() tea.Cmd {
+	t := m.selectedLibraryItem()
+	if t == nil {
+		return nil
+	}
+
+	videos := videoFiles(*t)
+	switch len(videos) {
+	case 0:
+		m.notice = "No video files in " + t.Name
+		return nil
+	case 1:
+		return m.play(t.InfoHash, videos[0])
+	}
+
+	m.chooserItem = t.InfoHash
+	m.chooserFiles = videos
+	m.view = viewChooser
+	m.currentPage, m.selectedID = 0, 0
+	return nil
+}
+
+// play hands the terminal over to the configured player until it exits.
+func (m *model) play(infoHash, file string) tea.Cmd {
+	cmd := commandFromTemplate(m.settings.Player.Player, map[string]string{
+		"file": file,
+		"dir":  filepath.Dir(file),
+	})
+	log.Printf("Playing: %s", strings.Join(cmd.Args, " "))
+
+	player := &playerCmd{Cmd: cmd}
+	return tea.Exec(player, func(err error) tea.Msg {
+		return playbackDoneMsg{infoHash: infoHash, file: file, ran: player.ran, err: err}
+	})
+}
+
+// playbackDone marks what was played as watched and, for series, moves on
+// to the next episode.
+func (m *model) playbackDone(msg playbackDoneMsg) tea.Cmd {
+	if msg.err != nil {
+		m.notice = "Player failed: " + msg.err.Error()
+		return nil
+	}
+
+	t := m.findLibraryItem(msg.infoHash)
+	if t == nil {
+		return nil
+	}
+	t.markWatched(msg.file)
+
+	if !m.settings.Player.AutoPlayNext {
+		return nil
+	}
+	if msg.ran < minPlayback {
+		log.Printf("Player returned after %s, not playing the next episode", msg.ran)
+		return nil
+	}
+	if next, file := m.nextEpisode(msg.file); next != nil {
+		m.notice = "Playing next: " + filepath.Base(file)
+		return m.play(next.InfoHash, file)
+	}
+	return nil
+}
+
+func (t *Torrent) markWatched(file string) {
+	for _, f := range t.WatchedFiles {
+		if f == file {
+			return
+		}
+	}
+	t.WatchedFiles = append(t.WatchedFiles, file)
+
+	watched := make(map[string]bool)
+	for _, f := range t.WatchedFiles {
+		watched[f] = true
+	}
+	t.Watched = true
+	for _, f := range videoFiles(*t) {
+		if !watched[f] {
+			t.Watched = false
+		}
+	}
+}
+
+// nextEpisode finds the episode after file anywhere in the library, going by
+// parsed show name, season and episode.
+func (m *model) nextEpisode(file string) (*Torrent, string) {
+	current := parseMediaName(filepath.Base(file))
+	if !current.IsEpisode() {
+		return nil, ""
+	}
+
+	var best *Torrent
+	var bestFile string
+	var bestInfo mediaInfo
+	for i := range m.Library {
+		t := &m.Library[i]
+		for _, f := range videoFiles(*t) {
+			info := parseMediaName(filepath.Base(f))
+			if !info.IsEpisode() || !strings.EqualFold(info.Show, current.Show) || !episodeAfter(info, current) {
+				continue
+			}
+			if best == nil || episodeAfter(bestInfo, info) {
+				best, bestFile, bestInfo = t, f, info
+			}
+		}
+	}
+	return best, bestFile
+}
+
+func episodeAfter(a, b mediaInfo) bool {
+	if a.Season != b.Season {
+		return a.Season > b.Season
+	}
+	return a.Episode > b.Episode
+}
+
+// openFolder opens the selected item's folder in the file manager without
+// waiting for it.
+func (m *model) openFolder() {
+	t := m.selectedLibraryItem()
+	if t == nil {
+		return
+	}
+
+	dir := t.Path
+	if dir == "" {
+		dir = t.dir()
+	}
+	cmd := commandFromTemplate(m.settings.Player.FileManager, map[string]string{"dir": dir})
+	if err := cmd.Start(); err != nil {
+		m.notice = "Couldn't open file manager: " + err.Error()
+		return
+	}
+	go cmd.Wait()
+}
+
+func (m model) renderChooserView() string {
+	lines := make([]string, len(m.chooserFiles))
+	for i, f := range m.chooserFiles {
+		line := filepath.Base(f)
+		if i == m.selectedID {
+			line = m.styles.SelectedRow.Render(line)
+		}
+		lines[i] = line
+	}
+
+	footer := lipgloss.NewStyle().
+		Background(lipgloss.Color("#4c566a")).
+		Foreground(lipgloss.Color("#eceff4")).
+		Padding(0, 1).
+		Render("enter to play, esc to go back")
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		strings.Join(lines, "\n"),
+		footer,
+	)
+}
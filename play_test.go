@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePlayer writes a player script that records its arguments, one per
+// line, to the returned file.
+func fakePlayer(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	out := filepath.Join(dir, "args")
+	script := filepath.Join(dir, "player")
+	body := "#!/bin/sh\nfor arg in \"$@\"; do printf '%s\\n' \"$arg\" >> " + out + "; done\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script, out
+}
+
+func TestPlayerGetsFileAsOneArgument(t *testing.T) {
+	script, out := fakePlayer(t)
+	file := "/media/Show; rm -rf ~/Show 'S01E01' $(x).mkv"
+
+	cmd := commandFromTemplate(script+" --fs {file}", map[string]string{"file": file, "dir": filepath.Dir(file)})
+	player := &playerCmd{Cmd: cmd}
+	if err := player.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(args) != 2 || args[0] != "--fs" || args[1] != file {
+		t.Errorf("player got %q, want [--fs %q]", args, file)
+	}
+	if player.ran <= 0 {
+		t.Errorf("player run time not recorded")
+	}
+}
+
+func TestPlaybackDone(t *testing.T) {
+	e1 := "/lib/Show.S01E01.mkv"
+	e2 := "/lib/Show.S01E02.mkv"
+	library := []Torrent{
+		{InfoHash: "A", Name: "Show S01E01", Files: []string{e1}},
+		{InfoHash: "B", Name: "Show S01E02", Files: []string{e2}},
+	}
+	script, _ := fakePlayer(t)
+
+	tests := []struct {
+		name     string
+		autoPlay bool
+		ran      time.Duration
+		wantNext bool
+	}{
+		{"auto play after real playback", true, minPlayback, true},
+		{"player returned at once", true, time.Second, false},
+		{"auto play off", false, minPlayback, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := DefaultSettings()
+			settings.Player.Player = script + " {file}"
+			settings.Player.AutoPlayNext = tt.autoPlay
+			m := New(settings)
+			m.Library = append([]Torrent(nil), library...)
+
+			cmd := m.playbackDone(playbackDoneMsg{infoHash: "A", file: e1, ran: tt.ran})
+			if got := cmd != nil; got != tt.wantNext {
+				t.Errorf("next episode started = %v, want %v", got, tt.wantNext)
+			}
+			if !m.Library[0].Watched {
+				t.Errorf("%s not marked watched", e1)
+			}
+		})
+	}
+}
+
+func TestAutoPlayNextIsOffByDefault(t *testing.T) {
+	if DefaultPlayerSettings().AutoPlayNext {
+		t.Error("auto_play_next defaults to on, but the default player doesn't block")
+	}
+}
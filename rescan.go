@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const untrackedPrefix = "untracked:"
+
+// diskUsage returns the space files actually take up on disk, which for
+// preallocated or sparse downloads can differ from their apparent size.
+func diskUsage(files []string) int64 {
+	var total int64
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			total += stat.Blocks * 512
+		} else {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// rescanLibrary reconciles the library with what is actually under the
+// download root: entries whose data is gone are flagged missing, folders
+// nothing knows about are imported as untracked entries, and sizes are
+// recomputed from disk.
+func (m *model) rescanLibrary() (missing, imported int, err error) {
+	known := make(map[string]bool)
+	for _, root := range m.categoryRoots() {
+		known[root] = true
+	}
+	for i := range m.Downloading {
+		known[m.Downloading[i].dir()] = true
+	}
+
+	for i := range m.Library {
+		t := &m.Library[i]
+		known[t.dir()] = true
+
+		var files []string
+		if _, err := os.Stat(t.dir()); err == nil {
+			files = listFiles(t.dir())
+		}
+		for _, f := range t.Files {
+			if _, err := os.Stat(f); err == nil && !strings.HasPrefix(f, t.dir()+string(filepath.Separator)) {
+				files = append(files, f)
+			}
+		}
+		for _, f := range files {
+			known[m.topLevelDir(f)] = true
+		}
+
+		t.Missing = len(files) == 0
+		if t.Missing {
+			missing++
+			log.Printf("Library item %s is missing from disk", t.Name)
+			continue
+		}
+		t.Files = files
+		t.Bytes = diskUsage(files)
+		t.Size = formatBytes(t.Bytes)
+	}
+
+	for _, root := range m.categoryRoots() {
+		entries, err := os.ReadDir(root)
+		if os.IsNotExist(err) && root != downloadRoot {
+			continue
+		}
+		if err != nil {
+			return missing, imported, err
+		}
+		for _, entry := range entries {
+			dir := filepath.Join(root, entry.Name())
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || known[dir] {
+				continue
+			}
+			known[dir] = true
+
+			files := listFiles(dir)
+			bytes := diskUsage(files)
+			var added time.Time
+			if info, err := entry.Info(); err == nil {
+				added = info.ModTime()
+			}
+			t := Torrent{
+				InfoHash:       untrackedPrefix + entry.Name(),
+				Name:           entry.Name(),
+				DownloadStatus: "Stored",
+				Files:          files,
+				Bytes:          bytes,
+				Size:           formatBytes(bytes),
+				NumFiles:       len(files),
+				Untracked:      true,
+				Path:           dir,
+				AddedAt:        added,
+			}
+			if root != downloadRoot {
+				t.InfoHash = untrackedPrefix + dir
+				t.Dir = root
+			}
+			m.Library = append(m.Library, t)
+			imported++
+			log.Printf("Imported untracked folder %s", dir)
+		}
+	}
+
+	return missing, imported, nil
+}
+
+// topLevelDir returns the folder directly under the download root or a
+// category directory that path lives in, or "" if it is outside them all.
+func (m *model) topLevelDir(path string) string {
+	roots := m.categoryRoots()
+	// Category directories may sit inside the download root, so they are
+	// tried first.
+	for i := len(roots) - 1; i >= 0; i-- {
+		root := roots[i]
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		first, _, _ := strings.Cut(rel, string(filepath.Separator))
+		return filepath.Join(root, first)
+	}
+	return ""
+}
+
+func libraryStatusText(t Torrent) string {
+	switch {
+	case t.Missing:
+		return "missing"
+	case t.Untracked:
+		return "untracked"
+	}
+	return ""
+}
+
+func rescanSummary(missing, imported int) string {
+	return fmt.Sprintf("Rescan done: %d missing, %d imported", missing, imported)
+}
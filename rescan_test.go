@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRescanLibrary(t *testing.T) {
+	downloads, _ := withPaths(t)
+	movies := filepath.Join(downloads, "Movies")
+	m := New(DefaultSettings())
+	m.settings.Categories = []Category{{Name: "Movies", Dir: movies}}
+
+	present := Torrent{InfoHash: "AAAAAAAA11", Name: "Present", DownloadStatus: "Stored", Size: "stale"}
+	gone := Torrent{InfoHash: "BBBBBBBB22", Name: "Gone", DownloadStatus: "Stored"}
+	organized := Torrent{InfoHash: "CCCCCCCC33", Name: "Organized", DownloadStatus: "Stored"}
+	running := Torrent{InfoHash: "DDDDDDDD44", Name: "Running", DownloadStatus: "Downloading"}
+	moved := filepath.Join(downloads, "TV", "Show", "Show - S01E01.mkv")
+	organized.Files = []string{filepath.Join(organized.dir(), "Show.S01E01.mkv"), moved}
+	writeFiles(t, map[string]int{
+		filepath.Join(present.dir(), "movie.mkv"): 4096,
+		moved:                                    10,
+		filepath.Join(running.dir(), "part.mkv"): 10,
+		filepath.Join(downloads, "Home Videos", "beach.mp4"):  10,
+		filepath.Join(downloads, ".hidden", "x"):              10,
+		filepath.Join(movies, "Film (2001)", "Film.2001.mkv"): 10,
+	})
+	m.Library = []Torrent{present, gone, organized}
+	m.Downloading = []Torrent{running}
+
+	missing, imported, err := m.rescanLibrary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing != 1 || imported != 2 {
+		t.Errorf("rescan found %d missing and imported %d, want 1 and 2: %+v", missing, imported, m.Library)
+	}
+
+	tests := []struct {
+		infoHash string
+		missing  bool
+		files    int
+	}{
+		{present.InfoHash, false, 1},
+		{gone.InfoHash, true, 0},
+		{organized.InfoHash, false, 1},
+		{untrackedPrefix + "Home Videos", false, 1},
+		{untrackedPrefix + filepath.Join(movies, "Film (2001)"), false, 1},
+	}
+	for _, tt := range tests {
+		item := m.findLibraryItem(tt.infoHash)
+		if item == nil {
+			t.Errorf("%s isn't in the library", tt.infoHash)
+			continue
+		}
+		if item.Missing != tt.missing || len(item.Files) != tt.files {
+			t.Errorf("%s: missing %v with %d files, want %v with %d", tt.infoHash, item.Missing, len(item.Files), tt.missing, tt.files)
+		}
+	}
+	if item := m.findLibraryItem(present.InfoHash); item.Bytes < 4096 || item.Size == "stale" {
+		t.Errorf("present entry's size wasn't recomputed: %d bytes, %q", item.Bytes, item.Size)
+	}
+	if item := m.findLibraryItem(untrackedPrefix + filepath.Join(movies, "Film (2001)")); item.dir() != filepath.Join(movies, "Film (2001)") {
+		t.Errorf("imported category folder points at %s", item.dir())
+	}
+
+	if _, imported, err := m.rescanLibrary(); err != nil || imported != 0 {
+		t.Errorf("a second rescan imported %d (%v), want nothing new", imported, err)
+	}
+}
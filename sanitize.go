@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxFileNameBytes leaves room under the usual 255 byte limit for the info
+// hash suffix and aria2's .aria2 control files.
+const maxFileNameBytes = 200
+
+// sanitizeFileName turns a torrent name, which comes straight from the
+// search provider, into a single safe path component: no separators, no
+// dot segments or hidden names, no control or reserved characters, NFC
+// normalized and not too long for the filesystem.
+func sanitizeFileName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == utf8.RuneError || unicode.IsControl(r):
+		case r == ' ' || strings.ContainsRune(`/\<>:"|?*`, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	// Normalizing after dropping characters, so nothing dropped sits
+	// between a letter and its combining mark.
+	name = strings.TrimLeft(norm.NFC.String(b.String()), ".")
+
+	if len(name) > maxFileNameBytes {
+		cut := maxFileNameBytes
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut]
+	}
+	name = strings.TrimRight(name, ".")
+
+	if name == "" {
+		return "torrent"
+	}
+	return name
+}
+
+// ensureInside refuses paths that aren't strictly inside one of roots, so a
+// bad name or a tampered state file can never point a destructive operation
+// at the roots themselves or anything outside them. Symlinked parents are
+// resolved before checking, and ".." is refused outright since the kernel
+// resolves it after symlinks, not before.
+func ensureInside(path string, roots ...string) error {
+	for _, part := range strings.Split(path, string(os.PathSeparator)) {
+		if part == ".." {
+			return fmt.Errorf("refusing to touch %s: it goes up a directory", path)
+		}
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	resolved := resolveParents(abs)
+
+	for _, root := range roots {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if !within(abs, root) {
+			continue
+		}
+		if realRoot, err := filepath.EvalSymlinks(root); err == nil && !within(resolved, realRoot) {
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("refusing to touch %s: it is not inside %s", path, strings.Join(roots, ", "))
+}
+
+// resolveParents resolves symlinks in the deepest existing parent of path,
+// leaving the final component and anything not created yet as they are.
+func resolveParents(path string) string {
+	dir, rest := filepath.Dir(path), filepath.Base(path)
+	for {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(real, rest)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+func within(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
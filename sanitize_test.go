@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzSanitizeFileName(f *testing.F) {
+	for _, seed := range []string{
+		"", ".", "..", "...", "../../etc/passwd", "/", "a/b\\c", ".hidden",
+		"Show S01E01 1080p", "name\x00with\x00nul", "tab\there\nnewline",
+		"été", "\xff\xfe broken utf8", "trailing dots...",
+		strings.Repeat("é", 150), strings.Repeat("a", 199) + "日本",
+		`con<>:"|?*`, "‮evil", strings.Repeat(".", 300),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		got := sanitizeFileName(name)
+		switch {
+		case got == "" || got == "." || got == "..":
+			t.Fatalf("sanitizeFileName(%q) = %q, not a usable name", name, got)
+		case strings.ContainsAny(got, "/\\\x00"):
+			t.Fatalf("sanitizeFileName(%q) = %q, has a separator or NUL", name, got)
+		case len(got) > maxFileNameBytes:
+			t.Fatalf("sanitizeFileName(%q) is %d bytes, over %d", name, len(got), maxFileNameBytes)
+		case !utf8.ValidString(got):
+			t.Fatalf("sanitizeFileName(%q) = %q, not valid UTF-8", name, got)
+		case strings.HasPrefix(got, "."):
+			t.Fatalf("sanitizeFileName(%q) = %q, hidden", name, got)
+		}
+		if filepath.Base(got) != got {
+			t.Fatalf("sanitizeFileName(%q) = %q, more than one path component", name, got)
+		}
+		if again := sanitizeFileName(got); again != got {
+			t.Fatalf("sanitizeFileName isn't stable: %q then %q", got, again)
+		}
+	})
+}
+
+// insideFixture lays out a root with a symlink to a directory inside it, a
+// symlink to one outside it, and a sibling that shares the root's prefix.
+func insideFixture(t testing.TB) string {
+	t.Helper()
+	base := t.TempDir()
+	for _, dir := range []string{"root/sub", "rootx", "outside"} {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(base, "root", "sub"), filepath.Join(base, "root", "in")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(base, "outside"), filepath.Join(base, "root", "out")); err != nil {
+		t.Fatal(err)
+	}
+	return base
+}
+
+func TestEnsureInside(t *testing.T) {
+	base := insideFixture(t)
+	root := filepath.Join(base, "root")
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{root, false},
+		{root + "/", false},
+		{filepath.Join(root, "file"), true},
+		{filepath.Join(root, "sub", "file"), true},
+		{filepath.Join(root, "new", "dirs", "file"), true},
+		{filepath.Join(base, "rootx", "file"), false},
+		{filepath.Join(base, "rootx"), false},
+		{root + "/../outside/file", false},
+		{root + "/sub/../file", false},
+		{filepath.Join(root, "in", "file"), true},
+		{filepath.Join(root, "in"), true},
+		{filepath.Join(root, "out"), true},
+		{filepath.Join(root, "out", "file"), false},
+		{filepath.Join(root, "out", "new", "file"), false},
+		{base, false},
+		{"/", false},
+	}
+	for _, tt := range tests {
+		if err := ensureInside(tt.path, root); (err == nil) != tt.ok {
+			t.Errorf("ensureInside(%q) = %v, want ok %v", tt.path, err, tt.ok)
+		}
+	}
+}
+
+func TestEnsureInsideSymlinkedRoot(t *testing.T) {
+	base := insideFixture(t)
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(filepath.Join(base, "root"), link); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureInside(filepath.Join(link, "new", "file"), link); err != nil {
+		t.Errorf("a path under a symlinked root was refused: %v", err)
+	}
+	if err := ensureInside(filepath.Join(link, "out", "file"), link); err == nil {
+		t.Error("a path escaping a symlinked root was allowed")
+	}
+}
+
+func FuzzEnsureInside(f *testing.F) {
+	for _, seed := range []string{
+		"", ".", "..", "file", "sub/file", "in/file", "out", "out/file",
+		"../rootx/file", "../root/file", "sub/../../outside", "a/./b",
+		"new/dirs/file", "//file", "sub//file", "out/../file", "in/../out/file",
+	} {
+		f.Add(seed)
+	}
+	base := insideFixture(f)
+	root := filepath.Join(base, "root")
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, rel string) {
+		if strings.ContainsRune(rel, 0) {
+			return
+		}
+		path := root + "/" + rel
+
+		// What the kernel would make of path: ".." after a symlink goes up
+		// from the link's target, so it is refused along with every other
+		// "..", and anything below out/ is outside.
+		want := true
+		var parts []string
+		for _, part := range strings.Split(rel, "/") {
+			switch part {
+			case "", ".":
+			case "..":
+				want = false
+			default:
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) == 0 || (parts[0] == "out" && len(parts) > 1) {
+			want = false
+		}
+
+		err := ensureInside(path, root)
+		if (err == nil) != want {
+			t.Fatalf("ensureInside(%q) = %v, want ok %v", path, err, want)
+		}
+		if err == nil {
+			if real := resolveParents(filepath.Clean(path)); !strings.HasPrefix(real, realRoot+"/") {
+				t.Fatalf("ensureInside(%q) allowed %s", path, real)
+			}
+		}
+	})
+}
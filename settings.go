@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	onQuitStop   = "stop"
+	onQuitDetach = "detach"
+)
+
+// settingsPath is where settings lived before they moved into the state
+// document, and from there into the config file.
+var settingsPath = filepath.Join(downloadRoot, ".settings.json")
+
+type Settings struct {
+	// DownloadDir holds downloads and the library. A leading ~/ is the home
+	// directory.
+	DownloadDir string `json:"download_dir" toml:"download_dir"`
+	// OnQuit decides what happens to running aria2c instances when sailor
+	// exits: "stop" saves their sessions and shuts them down, "detach" leaves
+	// them running and records their RPC ports so the next start reconnects.
+	OnQuit string `json:"on_quit" toml:"on_quit"`
+	// PollInterval is how often aria2c is asked for progress, and
+	// RefreshInterval how often the screen redraws and state is autosaved.
+	PollInterval    time.Duration `json:"poll_interval" toml:"poll_interval"`
+	RefreshInterval time.Duration `json:"refresh_interval" toml:"refresh_interval"`
+	RowsPerPage     int           `json:"rows_per_page" toml:"rows_per_page"`
+	// Trackers are added to every magnet link.
+	Trackers   []string          `json:"trackers" toml:"trackers"`
+	Categories []Category        `json:"categories" toml:"categories"`
+	Styles     StyleSettings     `json:"styles" toml:"styles"`
+	Retry      RetryPolicy       `json:"retry" toml:"retry"`
+	DiskSpace  DiskSpaceSettings `json:"disk_space" toml:"disk_space"`
+	DataCap    DataCapSettings   `json:"data_cap" toml:"data_cap"`
+	Hooks      []Hook            `json:"hooks" toml:"hooks"`
+	Extract    ExtractSettings   `json:"extract" toml:"extract"`
+	Organize   OrganizeSettings  `json:"organize" toml:"organize"`
+	Player     PlayerSettings    `json:"player" toml:"player"`
+	Stream     StreamSettings    `json:"stream" toml:"stream"`
+	DLNA       DLNASettings      `json:"dlna" toml:"dlna"`
+}
+
+func DefaultSettings() *Settings {
+	return &Settings{
+		DownloadDir:     "~/Downloads/Sailor",
+		OnQuit:          onQuitStop,
+		PollInterval:    3 * time.Second,
+		RefreshInterval: 2 * time.Second,
+		RowsPerPage:     30,
+		Trackers:        defaultTrackers,
+		Styles:          DefaultStyleSettings(),
+		Retry:           DefaultRetryPolicy(),
+		DiskSpace:       DefaultDiskSpaceSettings(),
+		DataCap:         DefaultDataCapSettings(),
+		Organize:        DefaultOrganizeSettings(),
+		Player:          DefaultPlayerSettings(),
+		Stream:          DefaultStreamSettings(),
+		DLNA:            DefaultDLNASettings(),
+	}
+}
+
+func (s *Settings) Validate() error {
+	if s.DownloadDir == "" {
+		return errors.New("download_dir must not be empty")
+	}
+	switch s.OnQuit {
+	case onQuitStop, onQuitDetach:
+	default:
+		return fmt.Errorf("on_quit must be %q or %q, got %q", onQuitStop, onQuitDetach, s.OnQuit)
+	}
+	if s.PollInterval < 500*time.Millisecond || s.RefreshInterval < 100*time.Millisecond {
+		return errors.New("poll_interval must be at least 500ms and refresh_interval at least 100ms")
+	}
+	if s.RowsPerPage < 1 {
+		return fmt.Errorf("rows_per_page must be at least 1, got %d", s.RowsPerPage)
+	}
+	if err := s.Retry.Validate(); err != nil {
+		return err
+	}
+	if err := s.DiskSpace.Validate(); err != nil {
+		return err
+	}
+	if err := s.DataCap.Validate(); err != nil {
+		return err
+	}
+	if err := s.Organize.Validate(); err != nil {
+		return err
+	}
+	if err := s.Player.Validate(); err != nil {
+		return err
+	}
+	for i := range s.Categories {
+		if err := s.Categories[i].Validate(); err != nil {
+			return err
+		}
+	}
+	for _, h := range s.Hooks {
+		if err := h.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// override is a setting that can also be given as a flag or an environment
+// variable, which win over the config file in that order.
+type override struct {
+	flag  string
+	env   string
+	usage string
+	set   func(s *Settings, value string) error
+}
+
+var overrides = []override{
+	{"download-dir", "SAILOR_DOWNLOAD_DIR", "directory for downloads and the library", func(s *Settings, v string) error {
+		s.DownloadDir = v
+		return nil
+	}},
+	{"on-quit", "SAILOR_ON_QUIT", `"stop" or "detach" running downloads on quit`, func(s *Settings, v string) error {
+		s.OnQuit = v
+		return nil
+	}},
+	{"poll-interval", "SAILOR_POLL_INTERVAL", "how often to poll aria2c, e.g. 3s", func(s *Settings, v string) (err error) {
+		s.PollInterval, err = time.ParseDuration(v)
+		return err
+	}},
+	{"refresh-interval", "SAILOR_REFRESH_INTERVAL", "how often to redraw and autosave, e.g. 2s", func(s *Settings, v string) (err error) {
+		s.RefreshInterval, err = time.ParseDuration(v)
+		return err
+	}},
+	{"rows-per-page", "SAILOR_ROWS_PER_PAGE", "table rows per page", func(s *Settings, v string) (err error) {
+		s.RowsPerPage, err = strconv.Atoi(v)
+		return err
+	}},
+}
+
+type options struct {
+	configPath string
+	stateDir   string
+	command    string
+	values     map[string]*string
+	set        map[string]bool
+}
+
+func parseOptions(args []string) (*options, error) {
+	opts := &options{values: make(map[string]*string), set: make(map[string]bool)}
+
+	flags := flag.NewFlagSet("sailor", flag.ContinueOnError)
+	flags.StringVar(&opts.configPath, "config", envOr("SAILOR_CONFIG", configPath), "config file")
+	flags.StringVar(&opts.stateDir, "state-dir", envOr("SAILOR_STATE_DIR", stateDir), "directory for state and logs")
+	for _, o := range overrides {
+		opts.values[o.flag] = flags.String(o.flag, "", fmt.Sprintf("%s (env %s)", o.usage, o.env))
+	}
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: sailor [flags] [config|undo]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	flags.Visit(func(f *flag.Flag) { opts.set[f.Name] = true })
+
+	opts.command = flags.Arg(0)
+	switch opts.command {
+	case "", "config", "undo":
+	default:
+		return nil, fmt.Errorf("unknown command %q", opts.command)
+	}
+	return opts, nil
+}
+
+func envOr(env, fallback string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// loadConfig reads the config file, or wherever settings were kept before
+// if it doesn't exist yet, and applies environment and flag overrides on
+// top. It never writes; see seedConfig.
+func loadConfig(opts *options) (*Settings, error) {
+	settings := DefaultSettings()
+
+	data, err := os.ReadFile(opts.configPath)
+	if os.IsNotExist(err) {
+		settings, err = legacySettings()
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if _, err := toml.Decode(string(data), settings); err != nil {
+		return nil, fmt.Errorf("%s: %w", opts.configPath, err)
+	}
+
+	for _, o := range overrides {
+		if v, ok := os.LookupEnv(o.env); ok {
+			if err := o.set(settings, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", o.env, err)
+			}
+		}
+		if opts.set[o.flag] {
+			if err := o.set(settings, *opts.values[o.flag]); err != nil {
+				return nil, fmt.Errorf("-%s: %w", o.flag, err)
+			}
+		}
+	}
+
+	if err := settings.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", opts.configPath, err)
+	}
+	return settings, nil
+}
+
+// seedConfig writes a config file when there is none yet, carrying over
+// settings from before the config file. Only the instance holding the state
+// lock calls it.
+func seedConfig(path string) error {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return err
+	}
+	settings, err := legacySettings()
+	if err != nil {
+		return err
+	}
+	if err := saveConfig(path, settings); err != nil {
+		return err
+	}
+	log.Printf("Wrote config to %s", path)
+	return nil
+}
+
+func saveConfig(path string, settings *Settings) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(settings); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// legacySettings finds settings from before the config file: in the state,
+// or in the settings file before that. Settings that didn't exist then keep
+// their defaults.
+func legacySettings() (*Settings, error) {
+	for _, store := range []Store{&boltStore{path: dbPath, readOnly: true}, &jsonStore{path: savePath, readOnly: true}} {
+		if _, err := os.Stat(store.Path()); err != nil {
+			continue
+		}
+		if doc, err := store.Load(); err == nil && doc.Settings != nil {
+			log.Printf("Found settings from before the config file in %s", store.Path())
+			return withNewDefaults(doc.Settings), nil
+		}
+	}
+
+	settings := DefaultSettings()
+	data, err := os.ReadFile(settingsPath)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", settingsPath, err)
+	}
+	log.Printf("Found settings from before the config file in %s", settingsPath)
+	return settings, nil
+}
+
+// withNewDefaults fills in the settings added along with the config file
+// and since.
+func withNewDefaults(s *Settings) *Settings {
+	defaults := DefaultSettings()
+	if s.DownloadDir == "" {
+		s.DownloadDir = defaults.DownloadDir
+	}
+	if s.PollInterval == 0 {
+		s.PollInterval = defaults.PollInterval
+	}
+	if s.RefreshInterval == 0 {
+		s.RefreshInterval = defaults.RefreshInterval
+	}
+	if s.RowsPerPage == 0 {
+		s.RowsPerPage = defaults.RowsPerPage
+	}
+	if s.Trackers == nil {
+		s.Trackers = defaults.Trackers
+	}
+	if s.Styles == (StyleSettings{}) {
+		s.Styles = defaults.Styles
+	}
+	if s.DataCap == (DataCapSettings{}) {
+		s.DataCap = defaults.DataCap
+	}
+	return s
+}
+
+// printConfig is `sailor config`: it shows the effective config with the
+// paths in use and reports whether it is valid.
+func printConfig(w io.Writer, opts *options, settings *Settings, err error) int {
+	fmt.Fprintf(w, "# config: %s\n# state:  %s\n# logs:   %s\n\n", opts.configPath, stateDir, logPath)
+	if err != nil {
+		fmt.Fprintf(w, "# invalid: %v\n", err)
+		return 1
+	}
+	if err := toml.NewEncoder(w).Encode(settings); err != nil {
+		fmt.Fprintf(w, "# %v\n", err)
+		return 1
+	}
+	return 0
+}
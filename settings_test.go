@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withPaths points the state and download paths at temporary directories
+// for the length of the test.
+func withPaths(t *testing.T) (string, string) {
+	t.Helper()
+	oldDownloads, oldState := downloadRoot, stateDir
+	t.Cleanup(func() { setPaths(oldDownloads, oldState) })
+
+	downloads, state := t.TempDir(), t.TempDir()
+	setPaths(downloads, state)
+	return downloads, state
+}
+
+func TestConfigCommandDoesNotWrite(t *testing.T) {
+	withPaths(t)
+	if err := os.WriteFile(settingsPath, []byte(`{"rows_per_page": 7}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseOptions([]string{"-config", filepath.Join(t.TempDir(), "config.toml"), "config"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := loadConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.RowsPerPage != 7 {
+		t.Errorf("rows_per_page = %d, want 7 from the old settings file", settings.RowsPerPage)
+	}
+	if _, err := os.Stat(opts.configPath); !os.IsNotExist(err) {
+		t.Errorf("loading the config wrote %s", opts.configPath)
+	}
+
+	if err := seedConfig(opts.configPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(settingsPath); err != nil {
+		t.Fatal(err)
+	}
+	settings, err = loadConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.RowsPerPage != 7 {
+		t.Errorf("rows_per_page = %d after seeding, want 7", settings.RowsPerPage)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type shutdownMsg struct{}
+
+var errProcessGroupExited = errors.New("aria2c process group exited")
+
+// shutdown persists state and then, depending on settings, either stops
+// every aria2c instance sailor owns or leaves them running detached.
+func (m *model) shutdown() {
+	m.quitting = true
+	if m.readOnly {
+		return
+	}
+
+	if m.dlna != nil {
+		m.dlna.Stop()
+	}
+	if m.streamServer != nil {
+		m.streamServer.Stop()
+	}
+
+	for i := range m.Downloading {
+		t := &m.Downloading[i]
+		if t.DownloadStatus != "Downloading" {
+			continue
+		}
+
+		if err := callAria2(t.Port, "aria2.saveSession", nil, nil); err != nil {
+			log.Printf("Couldn't save aria2 session for %s: %v", t.Name, err)
+		}
+
+		if m.settings.OnQuit == onQuitDetach {
+			t.Detached = true
+			log.Printf("Detaching from %s (Port: %d, PGID: %d)", t.Name, t.Port, t.PGID)
+			continue
+		}
+
+		if err := callAria2(t.Port, "aria2.shutdown", nil, nil); err != nil {
+			log.Printf("Couldn't shut down aria2c for %s, sending SIGTERM: %v", t.Name, err)
+			t.killAria2c(syscall.SIGTERM)
+		}
+	}
+
+	// Finished downloads whose aria2c hasn't gone since finishProcessing
+	// asked it to.
+	for _, t := range m.Library {
+		if t.PGID != 0 && processGroupAlive(t.PGID) {
+			log.Printf("Stopping %s's aria2c (PGID: %d)", t.Name, t.PGID)
+			t.killAria2c(syscall.SIGTERM)
+		}
+	}
+
+	if err := m.saveDownloadState(); err != nil {
+		log.Printf("Error saving download state: %v", err)
+	}
+	if err := m.store.Close(); err != nil {
+		log.Printf("Error closing download state: %v", err)
+	}
+}
+
+// handleSignals turns SIGINT, SIGTERM and SIGHUP into the same clean shutdown
+// as ctrl+c.
+func handleSignals(p *tea.Program) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	go func() {
+		for range sig {
+			p.Send(shutdownMsg{})
+		}
+	}()
+}
+
+// waitForGroup blocks until the process group is gone. It stands in for
+// cmd.Wait when supervising an aria2c sailor didn't start in this run.
+func waitForGroup(pgid int) func() error {
+	return func() error {
+		for processGroupAlive(pgid) {
+			time.Sleep(2 * time.Second)
+		}
+		return errProcessGroupExited
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// stateVersion is the schema version saveDownloadState writes. Bump it and
+// append to migrations whenever a change would misread older files.
+const stateVersion = 2
+
+// errNewerState is returned for state written by a newer sailor. It is
+// never treated as corruption: the data is fine, this binary is just old.
+var errNewerState = errors.New("newer than this sailor understands")
+
+type stateDocument struct {
+	Version int `json:"version"`
+	// Settings were kept here before the config file. They are only read,
+	// to seed it.
+	Settings  *Settings `json:"settings,omitempty"`
+	Downloads []Torrent `json:"downloads"`
+	Library   []Torrent `json:"library"`
+
+	// Searches and Events are only kept here by the JSON store.
+	Searches []SearchEntry `json:"searches,omitempty"`
+	Events   []Event       `json:"events,omitempty"`
+}
+
+// migrations[n] upgrades a version n document to version n+1. Documents
+// are handled as generic JSON so a migration never depends on the current
+// shape of Torrent.
+var migrations = []func(doc map[string]any) error{
+	migrateV0,
+	migrateV1,
+}
+
+// v0FieldNames maps the Go field names the bare v0 array was written with
+// onto the v1 keys. Anything not listed was transient and is dropped.
+var v0FieldNames = map[string]string{
+	"DownloadStatus": "download_status",
+	"size":           "size",
+	"bytes":          "bytes",
+	"info_hash":      "info_hash",
+	"name":           "name",
+	"num_files":      "num_files",
+	"Attempts":       "attempts",
+	"FailureReason":  "failure_reason",
+	"LastError":      "last_error",
+	"Detached":       "detached",
+	"Files":          "files",
+	"ExtractedFiles": "extracted_files",
+	"HookResults":    "hook_results",
+	"Missing":        "missing",
+	"Untracked":      "untracked",
+	"Path":           "path",
+	"Magnet":         "magnet",
+	"Provider":       "provider",
+	"Query":          "query",
+	"AddedAt":        "added_at",
+	"CompletedAt":    "completed_at",
+	"Watched":        "watched",
+	"WatchedFiles":   "watched_files",
+	"Verify":         "verify",
+	"Repair":         "repair",
+}
+
+// migrateV0 turns the bare array of torrents into separate download and
+// library sections, renames fields and keeps the aria2c port and process
+// group of running downloads so they can be reconnected to.
+func migrateV0(doc map[string]any) error {
+	torrents, _ := doc["torrents"].([]any)
+	downloads, library := []any{}, []any{}
+
+	for _, item := range torrents {
+		old, ok := item.(map[string]any)
+		if !ok {
+			return fmt.Errorf("v0 torrent is %T, not an object", item)
+		}
+
+		t := make(map[string]any)
+		for oldKey, newKey := range v0FieldNames {
+			if v, ok := old[oldKey]; ok {
+				t[newKey] = v
+			}
+		}
+		if t["download_status"] == "Stored" {
+			library = append(library, t)
+			continue
+		}
+		port, _ := old["Port"].(float64)
+		pgid, _ := old["PGID"].(float64)
+		if port > 0 {
+			t["reconnect"] = map[string]any{"port": port, "pgid": pgid}
+		}
+		downloads = append(downloads, t)
+	}
+
+	delete(doc, "torrents")
+	doc["downloads"] = downloads
+	doc["library"] = library
+	return nil
+}
+
+// migrateV1 pins the directories of existing torrents, which were named
+// after the torrent alone, before new ones started getting the info hash
+// added to keep them apart.
+func migrateV1(doc map[string]any) error {
+	for _, section := range []string{"downloads", "library"} {
+		torrents, _ := doc[section].([]any)
+		for _, item := range torrents {
+			t, ok := item.(map[string]any)
+			if !ok {
+				return fmt.Errorf("v1 %s entry is %T, not an object", section, item)
+			}
+			name, _ := t["name"].(string)
+			if untracked, _ := t["untracked"].(bool); untracked || name == "" {
+				continue
+			}
+			t["dir_name"] = strings.ReplaceAll(name, " ", "_")
+		}
+	}
+	return nil
+}
+
+// upgradeState runs the migrations from version from on doc.
+func upgradeState(doc map[string]any, from int) error {
+	if from < 0 {
+		return fmt.Errorf("state is version %d, which never existed", from)
+	}
+	for v := from; v < stateVersion; v++ {
+		if err := migrations[v](doc); err != nil {
+			return fmt.Errorf("migrating state from version %d: %w", v, err)
+		}
+		doc["version"] = float64(v + 1)
+	}
+	return nil
+}
+
+// decodeState reads a state file of any known version and upgrades it to
+// the current one. The returned version is what the file was written as.
+func decodeState(data []byte) (*stateDocument, int, error) {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, 0, err
+	}
+
+	var doc map[string]any
+	switch v := raw.(type) {
+	case []any:
+		doc = map[string]any{"version": float64(0), "torrents": v}
+	case map[string]any:
+		doc = v
+	case nil:
+		doc = map[string]any{"version": float64(0), "torrents": []any{}}
+	default:
+		return nil, 0, fmt.Errorf("state file holds %T", raw)
+	}
+
+	version, ok := doc["version"].(float64)
+	if !ok {
+		return nil, 0, fmt.Errorf("state file has no version")
+	}
+	from := int(version)
+	if from < 0 || float64(from) != version {
+		return nil, from, fmt.Errorf("state file has bad version %v", version)
+	}
+	if from > stateVersion {
+		return nil, from, fmt.Errorf("state file is version %d, %w (%d)", from, errNewerState, stateVersion)
+	}
+
+	if err := upgradeState(doc, from); err != nil {
+		return nil, from, err
+	}
+
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, from, err
+	}
+	var state stateDocument
+	if err := json.Unmarshal(upgraded, &state); err != nil {
+		return nil, from, err
+	}
+	return &state, from, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadFixture loads a state file from testdata through the JSON store, the
+// way an old state file is first read.
+func loadFixture(t *testing.T, name string) (*model, string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), ".downloading.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return loadState(t, path), path
+}
+
+func loadState(t *testing.T, path string) *model {
+	t.Helper()
+	m := New(DefaultSettings())
+	m.store = &jsonStore{path: path}
+	if err := m.loadDownloadState(); err != nil {
+		t.Fatalf("loading %s: %v", path, err)
+	}
+	return m
+}
+
+func names(torrents []Torrent) string {
+	var names []string
+	for _, t := range torrents {
+		names = append(names, t.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestLoadState(t *testing.T) {
+	tests := []struct {
+		fixture       string
+		from          int
+		wantDownloads string
+		wantLibrary   string
+	}{
+		{"state-v0.json", 0, "Some Show S01E01 1080p", "A Movie 2019"},
+		{"state-v1.json", 1, "Other Show S02E05 720p", "Documentary Part 1, Found On Disk"},
+		{"state-v2-stored-download.json", 2, "Some Show S01E01 1080p", "A Movie 2019, Documentary Part 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			m, path := loadFixture(t, tt.fixture)
+			if got := names(m.Downloading); got != tt.wantDownloads {
+				t.Errorf("downloads = %q, want %q", got, tt.wantDownloads)
+			}
+			if got := names(m.Library); got != tt.wantLibrary {
+				t.Errorf("library = %q, want %q", got, tt.wantLibrary)
+			}
+
+			backup := fmt.Sprintf("%s.v%d.bak", path, tt.from)
+			_, err := os.Stat(backup)
+			if migrated := err == nil; migrated != (tt.from < stateVersion) {
+				t.Errorf("backup %s kept = %v, want %v", filepath.Base(backup), migrated, tt.from < stateVersion)
+			}
+
+			if err := m.saveDownloadState(); err != nil {
+				t.Fatal(err)
+			}
+			reloaded := loadState(t, path)
+			if got := names(reloaded.Library); got != tt.wantLibrary {
+				t.Errorf("library after saving = %q, want %q", got, tt.wantLibrary)
+			}
+		})
+	}
+}
+
+func TestMigrateV0(t *testing.T) {
+	m, _ := loadFixture(t, "state-v0.json")
+
+	download := m.Downloading[0]
+	if download.Port != 6801 || download.PGID != 4242 {
+		t.Errorf("download reconnects to port %d, group %d, want 6801, 4242", download.Port, download.PGID)
+	}
+	if download.DirName != "Some_Show_S01E01_1080p" {
+		t.Errorf("download dir_name = %q, want the v0 directory", download.DirName)
+	}
+	if download.Attempts != 1 {
+		t.Errorf("download attempts = %d, want 1", download.Attempts)
+	}
+
+	movie := m.Library[0]
+	if movie.Reconnect != nil || movie.Port != 0 || movie.PGID != 0 {
+		t.Errorf("library entry kept aria2c details: %+v %d %d", movie.Reconnect, movie.Port, movie.PGID)
+	}
+	if !movie.Watched || len(movie.Files) != 1 || movie.Path == "" {
+		t.Errorf("library entry lost fields: %+v", movie)
+	}
+}
+
+func TestMigrateV1PinsDirectories(t *testing.T) {
+	m, _ := loadFixture(t, "state-v1.json")
+
+	if got := m.Downloading[0].DirName; got != "Other_Show_S02E05_720p" {
+		t.Errorf("download dir_name = %q, want Other_Show_S02E05_720p", got)
+	}
+	if got := m.Library[0].DirName; got != "Documentary_Part_1" {
+		t.Errorf("library dir_name = %q, want Documentary_Part_1", got)
+	}
+	if got := m.Library[1].DirName; got != "" {
+		t.Errorf("untracked entry got dir_name %q", got)
+	}
+}
+
+func TestStoredDownloadKeepsLibraryCopy(t *testing.T) {
+	m, _ := loadFixture(t, "state-v2-stored-download.json")
+
+	movie := m.findLibraryItem("BBBB000000000000000000000000000000000002")
+	if movie == nil || !movie.Watched {
+		t.Fatalf("library copy of A Movie 2019 was replaced: %+v", movie)
+	}
+	if m.findDownload(movie.InfoHash) != nil {
+		t.Error("A Movie 2019 is still in downloads")
+	}
+	if doc := m.findLibraryItem("DDDD000000000000000000000000000000000004"); doc == nil || doc.Reconnect != nil {
+		t.Errorf("Documentary Part 1 wasn't moved to the library cleanly: %+v", doc)
+	}
+}
+
+func TestDecodeStateRejectsNewerVersion(t *testing.T) {
+	_, from, err := decodeState([]byte(`{"version": 99, "downloads": [], "library": []}`))
+	if err == nil || from != 99 {
+		t.Errorf("decodeState = %d, %v, want an error for version 99", from, err)
+	}
+
+	for _, version := range []string{"-1", "-100", "1.5"} {
+		if _, _, err := decodeState([]byte(`{"version": ` + version + `, "downloads": [], "library": []}`)); err == nil {
+			t.Errorf("decodeState accepted version %s", version)
+		}
+	}
+	if err := upgradeState(map[string]any{}, -1); err == nil {
+		t.Error("upgradeState ran migrations from version -1")
+	}
+}
+
+func TestFinishProcessingLeavesDownloads(t *testing.T) {
+	m := New(DefaultSettings())
+	m.Downloading = []Torrent{
+		{InfoHash: "A", Name: "Finished", DownloadStatus: "Processing"},
+		{InfoHash: "B", Name: "Still going", DownloadStatus: "Downloading"},
+	}
+	m.finishProcessing(Torrent{InfoHash: "A", Name: "Finished", Files: []string{"/x/Finished.mkv"}})
+
+	if got := names(m.Downloading); got != "Still going" {
+		t.Errorf("downloads = %q, want only Still going", got)
+	}
+	item := m.findLibraryItem("A")
+	if item == nil || item.DownloadStatus != "Stored" || len(item.Files) != 1 {
+		t.Errorf("library entry = %+v, want the stored download", item)
+	}
+}
+
+func TestOnlyRunningDownloadsAreReconnected(t *testing.T) {
+	m := New(DefaultSettings())
+	m.Downloading = []Torrent{
+		{InfoHash: "A", DownloadStatus: "Downloading", Port: 6801, PGID: 4242},
+		{InfoHash: "B", DownloadStatus: "Failed", Port: 6802, PGID: 4343},
+	}
+	doc, _, err := m.stateDocument()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := doc.Downloads[0].Reconnect; r == nil || r.Port != 6801 || r.PGID != 4242 {
+		t.Errorf("running download reconnects to %+v", r)
+	}
+	if r := doc.Downloads[1].Reconnect; r != nil {
+		t.Errorf("failed download keeps a stale process group: %+v", r)
+	}
+}
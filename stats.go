@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	viewStats = "stats"
+
+	// transferInterval is how often transfer events are written.
+	transferInterval = time.Minute
+	// statsDays is how many days the sparklines cover.
+	statsDays = 30
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// transferCounters tracks what each download moved since the last transfer
+// event. Traffic is counted from aria2's global download and upload speeds
+// on every poll, so data aria2 resumes or hash checks from disk isn't
+// counted and a restarted aria2c doesn't throw the count off. It is only
+// touched by the poller.
+type transferCounters struct {
+	at     time.Time
+	polled time.Time
+	moved  map[string][2]int64
+}
+
+// recordTransfers adds up what downloads with a running aria2c moved since
+// the last poll and, every transferInterval, writes a transfer event for every download
+// that moved bytes.
+func (m *model) recordTransfers() {
+	c := &m.transfers
+	now := time.Now()
+	if c.moved == nil {
+		c.moved = make(map[string][2]int64)
+		c.at, c.polled = now, now
+	}
+	elapsed := now.Sub(c.polled).Seconds()
+	c.polled = now
+	for _, t := range m.Downloading {
+		if t.Port != 0 {
+			moved := c.moved[t.InfoHash]
+			moved[0] += int64(float64(t.DownloadRate) * elapsed)
+			moved[1] += int64(float64(t.UploadRate) * elapsed)
+			c.moved[t.InfoHash] = moved
+		}
+	}
+	if now.Sub(c.at) < transferInterval {
+		return
+	}
+	seconds := now.Sub(c.at).Seconds()
+	c.at = now
+
+	moved := c.moved
+	c.moved = make(map[string][2]int64)
+	for _, t := range m.Downloading {
+		down, up := moved[t.InfoHash][0], moved[t.InfoHash][1]
+		if down == 0 && up == 0 {
+			continue
+		}
+
+		if m.store == nil || m.readOnly {
+			continue
+		}
+		e := Event{At: c.at, Kind: eventTransfer, InfoHash: t.InfoHash, Name: t.Name,
+			Bytes: down, Uploaded: up, Seconds: seconds, Provider: t.Provider}
+		if err := m.store.AddEvent(e); err != nil {
+			log.Printf("Couldn't record transfer for %s: %v", t.Name, err)
+		}
+	}
+}
+
+type periodStats struct {
+	Name       string
+	Downloaded int64
+	Uploaded   int64
+	Completed  int
+	Seconds    float64
+}
+
+// averageSpeed is the download speed over the time downloads were running.
+func (p periodStats) averageSpeed() int64 {
+	if p.Seconds == 0 {
+		return 0
+	}
+	return int64(float64(p.Downloaded) / p.Seconds)
+}
+
+type dayStats struct {
+	periodStats
+	Day time.Time
+}
+
+type providerCount struct {
+	Provider  string
+	Completed int
+}
+
+// statsReport sums up the event log for the stats view.
+type statsReport struct {
+	Periods   []periodStats
+	Days      []dayStats
+	Hours     [24]int64
+	Providers []providerCount
+	// CapDown and CapUp are what the data cap's cycle, starting at
+	// CapSince, has used.
+	CapSince       time.Time
+	CapDown, CapUp int64
+}
+
+func buildStats(events []Event, now time.Time) *statsReport {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	week := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	report := &statsReport{Periods: []periodStats{
+		{Name: "Today"}, {Name: "This week"}, {Name: "This month"}, {Name: "All time"},
+	}}
+	starts := []time.Time{today, week, month, {}}
+
+	days := make(map[time.Time]*dayStats)
+	providers := make(map[string]int)
+	for _, e := range events {
+		at := e.At.In(now.Location())
+		day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, now.Location())
+		d := days[day]
+		if d == nil {
+			d = &dayStats{Day: day}
+			days[day] = d
+		}
+
+		add := func(p *periodStats) {
+			switch e.Kind {
+			case eventTransfer:
+				p.Downloaded += e.Bytes
+				p.Uploaded += e.Uploaded
+				p.Seconds += e.Seconds
+			case eventCompleted:
+				p.Completed++
+			}
+		}
+		add(&d.periodStats)
+		for i, start := range starts {
+			if !at.Before(start) {
+				add(&report.Periods[i])
+			}
+		}
+
+		switch e.Kind {
+		case eventTransfer:
+			report.Hours[at.Hour()] += e.Bytes
+		case eventCompleted:
+			provider := e.Provider
+			if provider == "" {
+				provider = "unknown"
+			}
+			providers[provider]++
+		}
+	}
+
+	for _, d := range days {
+		report.Days = append(report.Days, *d)
+	}
+	sort.Slice(report.Days, func(i, j int) bool { return report.Days[i].Day.Before(report.Days[j].Day) })
+
+	for provider, n := range providers {
+		report.Providers = append(report.Providers, providerCount{provider, n})
+	}
+	sort.Slice(report.Providers, func(i, j int) bool {
+		a, b := report.Providers[i], report.Providers[j]
+		if a.Completed != b.Completed {
+			return a.Completed > b.Completed
+		}
+		return a.Provider < b.Provider
+	})
+	return report
+}
+
+// loadStats reads the event log into a fresh report.
+func (m *model) loadStats() error {
+	if m.store == nil {
+		return fmt.Errorf("no state is loaded")
+	}
+	events, err := m.store.Events(time.Time{})
+	if err != nil {
+		return err
+	}
+	m.stats = buildStats(events, time.Now())
+
+	m.stats.CapSince = m.settings.DataCap.cycleStart(time.Now())
+	for _, e := range events {
+		if e.Kind == eventTransfer && !e.At.Before(m.stats.CapSince) {
+			m.stats.CapDown += e.Bytes
+			m.stats.CapUp += e.Uploaded
+		}
+	}
+	return nil
+}
+
+// lastDays returns the last n days of the report, oldest first, with
+// empty days filled in.
+func (r *statsReport) lastDays(n int, now time.Time) []dayStats {
+	byDay := make(map[time.Time]dayStats)
+	for _, d := range r.Days {
+		byDay[d.Day] = d
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	days := make([]dayStats, n)
+	for i := range days {
+		day := today.AddDate(0, 0, i-n+1)
+		days[i] = byDay[day]
+		days[i].Day = day
+	}
+	return days
+}
+
+func sparkline(values []int64) string {
+	var max int64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		if v <= 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		b.WriteRune(sparkBlocks[int(v*int64(len(sparkBlocks)-1)/max)])
+	}
+	return b.String()
+}
+
+// bar is a horizontal bar of value against max, padded to width.
+func bar(value, max int64, width int) string {
+	n := 0
+	if max > 0 && value > 0 {
+		n = int(value * int64(width) / max)
+		if n == 0 {
+			n = 1
+		}
+	}
+	return strings.Repeat("█", n) + strings.Repeat(" ", width-n)
+}
+
+func (m model) renderStatsView() string {
+	r := m.stats
+	if r == nil {
+		return "No statistics yet."
+	}
+	label := lipgloss.NewStyle().Foreground(lipgloss.Color("#5e81ac")).Bold(true)
+	accent := lipgloss.NewStyle().Foreground(lipgloss.Color(m.settings.Styles.Border))
+
+	var lines []string
+	lines = append(lines, fmt.Sprintf("%-12s %12s %12s %10s %14s", "", "Downloaded", "Uploaded", "Completed", "Avg speed"))
+	for _, p := range r.Periods {
+		lines = append(lines, fmt.Sprintf("%-12s %12s %12s %10d %14s", p.Name,
+			formatBytes(p.Downloaded), formatBytes(p.Uploaded), p.Completed,
+			formatSpeed(strconv.FormatInt(p.averageSpeed(), 10))))
+	}
+
+	if c := m.settings.DataCap; c.enabled() {
+		line := "Data cap since " + r.CapSince.Format("Jan 2") + ":"
+		if c.DownloadGB > 0 {
+			line += fmt.Sprintf(" %s of %s down", formatBytes(r.CapDown), formatBytes(gigabytes(c.DownloadGB)))
+		}
+		if c.UploadGB > 0 {
+			line += fmt.Sprintf(" %s of %s up", formatBytes(r.CapUp), formatBytes(gigabytes(c.UploadGB)))
+		}
+		lines = append(lines, "", line)
+	}
+
+	days := r.lastDays(statsDays, time.Now())
+	down, up := make([]int64, len(days)), make([]int64, len(days))
+	for i, d := range days {
+		down[i], up[i] = d.Downloaded, d.Uploaded
+	}
+	lines = append(lines, "", label.Render(fmt.Sprintf("Last %d days", statsDays)),
+		"Down "+accent.Render(sparkline(down)),
+		"Up   "+accent.Render(sparkline(up)))
+
+	lines = append(lines, "", label.Render("Busiest hours"))
+	hours := make([]int, 24)
+	for h := range hours {
+		hours[h] = h
+	}
+	sort.SliceStable(hours, func(i, j int) bool { return r.Hours[hours[i]] > r.Hours[hours[j]] })
+	top := r.Hours[hours[0]]
+	for _, h := range hours[:5] {
+		if r.Hours[h] == 0 {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%02d:00 %s %s", h, accent.Render(bar(r.Hours[h], top, 30)), formatBytes(r.Hours[h])))
+	}
+
+	lines = append(lines, "", label.Render("Top providers"))
+	for i, p := range r.Providers {
+		if i == 5 {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%-10s %s %d", p.Provider,
+			accent.Render(bar(int64(p.Completed), int64(r.Providers[0].Completed), 30)), p.Completed))
+	}
+
+	footer := lipgloss.NewStyle().
+		Background(lipgloss.Color("#4c566a")).
+		Foreground(lipgloss.Color("#eceff4")).
+		Padding(0, 1).
+		Render("r to refresh, e to export CSV")
+
+	return lipgloss.JoinVertical(lipgloss.Left, strings.Join(lines, "\n"), "", footer)
+}
+
+// exportStats writes the per-day statistics as CSV to the state directory
+// and returns the file's path.
+func (m *model) exportStats() (string, error) {
+	if m.stats == nil {
+		return "", fmt.Errorf("no statistics to export")
+	}
+	path := filepath.Join(stateDir, "stats-"+time.Now().Format("2006-01-02")+".csv")
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"date", "downloaded_bytes", "uploaded_bytes", "completed", "download_seconds"})
+	for _, d := range m.stats.Days {
+		w.Write([]string{
+			d.Day.Format("2006-01-02"),
+			strconv.FormatInt(d.Downloaded, 10),
+			strconv.FormatInt(d.Uploaded, 10),
+			strconv.Itoa(d.Completed),
+			strconv.FormatFloat(d.Seconds, 'f', 0, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return path, file.Close()
+}
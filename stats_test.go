@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildStats(t *testing.T) {
+	// A Wednesday, so the week started on Monday the 12th.
+	now := time.Date(2026, 10, 14, 15, 30, 0, 0, time.UTC)
+	at := func(day, hour int) time.Time { return time.Date(2026, 10, day, hour, 0, 0, 0, time.UTC) }
+	events := []Event{
+		{At: at(14, 10), Kind: eventTransfer, Bytes: 100, Uploaded: 10, Seconds: 60},
+		{At: at(14, 11), Kind: eventCompleted, Provider: "apibay"},
+		{At: at(12, 9), Kind: eventTransfer, Bytes: 200, Seconds: 60},
+		{At: at(2, 10), Kind: eventTransfer, Bytes: 400, Uploaded: 40, Seconds: 120},
+		{At: at(2, 10), Kind: eventAdded},
+		{At: time.Date(2026, 9, 30, 23, 0, 0, 0, time.UTC), Kind: eventCompleted},
+		{At: time.Date(2026, 9, 20, 8, 0, 0, 0, time.UTC), Kind: eventCompleted, Provider: "apibay"},
+	}
+	r := buildStats(events, now)
+
+	want := []periodStats{
+		{Name: "Today", Downloaded: 100, Uploaded: 10, Completed: 1, Seconds: 60},
+		{Name: "This week", Downloaded: 300, Uploaded: 10, Completed: 1, Seconds: 120},
+		{Name: "This month", Downloaded: 700, Uploaded: 50, Completed: 1, Seconds: 240},
+		{Name: "All time", Downloaded: 700, Uploaded: 50, Completed: 3, Seconds: 240},
+	}
+	for i, p := range r.Periods {
+		if p != want[i] {
+			t.Errorf("period %d = %+v, want %+v", i, p, want[i])
+		}
+	}
+	if got := r.Periods[0].averageSpeed(); got != 1 {
+		t.Errorf("today's average speed = %d, want 1", got)
+	}
+
+	if len(r.Days) != 5 || !r.Days[0].Day.Equal(time.Date(2026, 9, 20, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("days = %+v, want five days from Sept 20", r.Days)
+	}
+	if r.Hours[10] != 500 || r.Hours[9] != 200 || r.Hours[11] != 0 {
+		t.Errorf("hours 9-11 = %v, want 200, 500, 0", r.Hours[9:12])
+	}
+	if len(r.Providers) != 2 || r.Providers[0] != (providerCount{"apibay", 2}) || r.Providers[1] != (providerCount{"unknown", 1}) {
+		t.Errorf("providers = %+v, want apibay 2, unknown 1", r.Providers)
+	}
+
+	last := r.lastDays(3, now)
+	if last[0].Downloaded != 200 || last[1].Downloaded != 0 || last[2].Downloaded != 100 {
+		t.Errorf("last three days = %+v", last)
+	}
+}
+
+// rewind moves the transfer counters' clocks back, as if d had passed.
+func rewind(m *model, d time.Duration) {
+	m.transfers.at = m.transfers.at.Add(-d)
+	m.transfers.polled = m.transfers.polled.Add(-d)
+}
+
+func TestRecordTransfers(t *testing.T) {
+	m := New(DefaultSettings())
+	store := &jsonStore{path: filepath.Join(t.TempDir(), "state.json")}
+	m.store = store
+	m.Downloading = []Torrent{
+		{InfoHash: "A", Name: "Show", DownloadStatus: "Downloading", Port: 6801, DownloadRate: 1000, UploadRate: 100},
+		{InfoHash: "B", Name: "Exited", DownloadStatus: "Failed", DownloadRate: 1000},
+	}
+
+	m.recordTransfers()
+	rewind(m, transferInterval/2)
+	m.recordTransfers()
+	if len(store.events) != 0 {
+		t.Fatalf("a transfer was written before the interval was up: %+v", store.events)
+	}
+	rewind(m, transferInterval/2)
+	m.recordTransfers()
+	if len(store.events) != 1 {
+		t.Fatalf("events = %+v, want one transfer for the running download", store.events)
+	}
+	if e := store.events[0]; e.InfoHash != "A" || e.Bytes < 60000 || e.Bytes > 61000 || e.Uploaded < 6000 || e.Uploaded > 6100 {
+		t.Errorf("transfer = %s %d down, %d up, want a minute at 1000 B/s down and 100 B/s up", e.InfoHash, e.Bytes, e.Uploaded)
+	}
+
+	m.Downloading[0].DownloadRate, m.Downloading[0].UploadRate = 0, 0
+	rewind(m, transferInterval)
+	m.recordTransfers()
+	if len(store.events) != 1 {
+		t.Errorf("an idle download got a transfer: %+v", store.events[1:])
+	}
+}
+
+func TestPausedDownloadStopsCountingUsage(t *testing.T) {
+	m := New(DefaultSettings())
+	m.Downloading = []Torrent{
+		{InfoHash: "A", Name: "Show", DownloadStatus: "Downloading", Port: 6801, Size: "1.00 GB"},
+		{InfoHash: "B", Name: "Done", DownloadStatus: "Processing", Port: 6802},
+	}
+	active := &aria2Status{GID: "1", Status: "active", CompletedLength: "1024"}
+	stat := &aria2GlobalStat{DownloadSpeed: "1000", UploadSpeed: "100"}
+	m.downloadInfo(downloadInfoMsg{infoHash: "A", port: 6801, info: active, stat: stat})
+	m.downloadInfo(downloadInfoMsg{infoHash: "B", port: 6802, stat: &aria2GlobalStat{DownloadSpeed: "0", UploadSpeed: "500"}})
+
+	m.recordTransfers()
+	rewind(m, 10*time.Second)
+	m.recordTransfers()
+	moved := m.transfers.moved
+	if a := moved["A"]; a[0] < 9900 || a[1] < 990 {
+		t.Fatalf("running download moved %v in 10s, want about 10000 down and 1000 up", a)
+	}
+	if b := moved["B"]; b[1] < 4900 {
+		t.Fatalf("seeding download uploaded %d in 10s, want about 5000", b[1])
+	}
+
+	// The cap pauses everything: tellActive comes back empty and the
+	// seeding aria2c stops answering.
+	m.downloadInfo(downloadInfoMsg{infoHash: "A", port: 6801})
+	m.downloadInfo(downloadInfoMsg{infoHash: "B", port: 6802, err: errors.New("connection refused")})
+	before := maps.Clone(m.transfers.moved)
+	rewind(m, 10*time.Second)
+	m.recordTransfers()
+	for hash, after := range m.transfers.moved {
+		if after != before[hash] {
+			t.Errorf("%s kept counting while paused: %v, then %v", hash, before[hash], after)
+		}
+	}
+}
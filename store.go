@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Store persists sailor's state. The bolt store is what sailor runs on;
+// the JSON store keeps the original single-file format around for small
+// setups and tests.
+type Store interface {
+	Load() (*stateDocument, error)
+	Save(doc *stateDocument) error
+	AddSearch(query string) error
+	Searches(limit int) ([]SearchEntry, error)
+	AddEvent(e Event) error
+	Events(since time.Time) ([]Event, error)
+	// Path is the file the store lives in, for noticing another instance's
+	// writes.
+	Path() string
+	// Close releases the store at shutdown.
+	Close() error
+}
+
+type SearchEntry struct {
+	At    time.Time `json:"at"`
+	Query string    `json:"query"`
+}
+
+const (
+	eventAdded     = "added"
+	eventCompleted = "completed"
+	eventFailed    = "failed"
+	eventRemoved   = "removed"
+	// eventTransfer records the bytes a download moved since the last one,
+	// for the stats view.
+	eventTransfer = "transfer"
+)
+
+type Event struct {
+	At       time.Time `json:"at"`
+	Kind     string    `json:"kind"`
+	InfoHash string    `json:"info_hash,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Bytes    int64     `json:"bytes,omitempty"`
+	Uploaded int64     `json:"uploaded,omitempty"`
+	// Seconds is how long a transfer event covers.
+	Seconds  float64 `json:"seconds,omitempty"`
+	Provider string  `json:"provider,omitempty"`
+}
+
+// openStore opens the bolt store, moving an existing JSON state file into it
+// the first time.
+func openStore(readOnly bool) (Store, error) {
+	store := &boltStore{path: dbPath, readOnly: readOnly}
+	if _, err := os.Stat(dbPath); err == nil || readOnly {
+		return store, nil
+	}
+	if _, err := os.Stat(savePath); os.IsNotExist(err) {
+		return store, nil
+	}
+
+	old := &jsonStore{path: savePath}
+	doc, err := old.Load()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s to migrate: %w", savePath, err)
+	}
+	if err := store.Save(doc); err != nil {
+		return nil, fmt.Errorf("migrating to %s: %w", dbPath, err)
+	}
+	for _, s := range doc.Searches {
+		store.addSearch(s)
+	}
+	for _, e := range doc.Events {
+		store.AddEvent(e)
+	}
+
+	migrated := savePath + ".migrated"
+	if err := os.Rename(savePath, migrated); err != nil {
+		return nil, err
+	}
+	log.Printf("Moved download state into %s, old file kept at %s", dbPath, migrated)
+	return store, nil
+}
+
+func (m *model) recordEvent(kind string, t Torrent) {
+	if m.store == nil || m.readOnly {
+		return
+	}
+	e := Event{At: time.Now(), Kind: kind, InfoHash: t.InfoHash, Name: t.Name, Bytes: t.Bytes, Provider: t.Provider}
+	if err := m.store.AddEvent(e); err != nil {
+		log.Printf("Couldn't record %s event for %s: %v", kind, t.Name, err)
+	}
+}
+
+func (m *model) recordSearch(query string) {
+	if m.store == nil || m.readOnly {
+		return
+	}
+	if err := m.store.AddSearch(query); err != nil {
+		log.Printf("Couldn't record search: %v", err)
+	}
+	m.loadSearchSuggestions()
+}
+
+// loadSearchSuggestions offers recent searches as completions in the search
+// field.
+func (m *model) loadSearchSuggestions() {
+	searches, err := m.store.Searches(searchHistorySize)
+	if err != nil {
+		log.Printf("Couldn't read search history: %v", err)
+		return
+	}
+	seen := make(map[string]bool)
+	var suggestions []string
+	for _, s := range searches {
+		if !seen[s.Query] {
+			seen[s.Query] = true
+			suggestions = append(suggestions, s.Query)
+		}
+	}
+	m.searchField.SetSuggestions(suggestions)
+}
+
+const searchHistorySize = 100
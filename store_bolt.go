@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var dbPath = filepath.Join(downloadRoot, "sailor.db")
+
+var (
+	bucketMeta      = []byte("meta")
+	bucketDownloads = []byte("downloads")
+	bucketLibrary   = []byte("library")
+	bucketSearches  = []byte("searches")
+	bucketEvents    = []byte("events")
+
+	keyVersion  = []byte("version")
+	keySettings = []byte("settings")
+)
+
+// errCorruptState marks data in the database that doesn't decode.
+var errCorruptState = errors.New("corrupt state")
+
+// corrupt reports whether err means the database is damaged, as opposed to
+// locked by another instance, unreadable or written by a newer sailor, all
+// of which leave the file alone.
+func corrupt(err error) bool {
+	return errors.Is(err, bolt.ErrInvalid) || errors.Is(err, bolt.ErrChecksum) || errors.Is(err, errCorruptState)
+}
+
+// snapshotInterval is how often at most the instance that owns the database
+// copies it out for read-only instances.
+const snapshotInterval = 10 * time.Second
+
+// boltStore keeps each torrent under its own key, so a save only writes the
+// items that changed. The instance that owns the state opens the database
+// once and keeps it until Close. That locks it, so read-only instances
+// read the snapshot Save keeps next to it instead.
+type boltStore struct {
+	path     string
+	readOnly bool
+
+	mu            sync.Mutex
+	db            *bolt.DB
+	snapshotAt    time.Time
+	snapshotTimer *time.Timer
+}
+
+// Path is the database, or for a read-only store the snapshot once the
+// owning instance has written one, since that is what changes with its
+// writes.
+func (s *boltStore) Path() string {
+	if s.readOnly {
+		if _, err := os.Stat(snapshotPath(s.path)); err == nil {
+			return snapshotPath(s.path)
+		}
+	}
+	return s.path
+}
+
+func snapshotPath(path string) string {
+	return path + ".snapshot"
+}
+
+// handle returns the database, opening it the first time.
+func (s *boltStore) handle() (*bolt.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		return s.db, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+	return db, nil
+}
+
+// Close closes the database after writing a last snapshot.
+func (s *boltStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return nil
+	}
+	if s.snapshotTimer != nil {
+		s.snapshotTimer.Stop()
+		s.snapshotTimer = nil
+	}
+	if err := s.writeSnapshot(); err != nil {
+		log.Printf("Couldn't write download state snapshot: %v", err)
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// discard closes the database without a snapshot, before the file is moved
+// aside as corrupt.
+func (s *boltStore) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		s.db.Close()
+		s.db = nil
+	}
+}
+
+// scheduleSnapshot writes a snapshot snapshotInterval after the last one,
+// so a burst of saves costs one copy. s.mu must be held.
+func (s *boltStore) scheduleSnapshot() {
+	if s.db == nil || s.snapshotTimer != nil {
+		return
+	}
+	s.snapshotTimer = time.AfterFunc(max(snapshotInterval-time.Since(s.snapshotAt), 0), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.snapshotTimer = nil
+		if s.db == nil {
+			return
+		}
+		if err := s.writeSnapshot(); err != nil {
+			log.Printf("Couldn't write download state snapshot: %v", err)
+		}
+	})
+}
+
+// writeSnapshot copies the database out for read-only instances, through a
+// temporary file so they never open half a copy. s.mu must be held.
+func (s *boltStore) writeSnapshot() error {
+	tmp := snapshotPath(s.path) + ".tmp"
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(tmp, 0644)
+	})
+	if err == nil {
+		err = os.Rename(tmp, snapshotPath(s.path))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.snapshotAt = time.Now()
+	return nil
+}
+
+func (s *boltStore) update(fn func(tx *bolt.Tx) error) error {
+	if s.readOnly {
+		return errors.New("store is read-only")
+	}
+	db, err := s.handle()
+	if err != nil {
+		return err
+	}
+	return db.Update(fn)
+}
+
+// view reads the database. Read-only stores open it per read, falling back
+// to the snapshot while another instance has it open.
+func (s *boltStore) view(fn func(tx *bolt.Tx) error) error {
+	if !s.readOnly {
+		db, err := s.handle()
+		if err != nil {
+			return err
+		}
+		return db.View(fn)
+	}
+
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: 100 * time.Millisecond, ReadOnly: true})
+	if errors.Is(err, bolt.ErrTimeout) {
+		db, err = bolt.Open(snapshotPath(s.path), 0644, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+// Load reads the database, restoring the newest backup that opens when it
+// is corrupt. Any other error is returned as is.
+func (s *boltStore) Load() (*stateDocument, error) {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		log.Println("Download state database does not exist. Starting fresh.")
+		return &stateDocument{Version: stateVersion}, nil
+	}
+
+	doc, err := s.load()
+	if err == nil || s.readOnly || !corrupt(err) {
+		return doc, err
+	}
+	log.Printf("Download state is unreadable: %v", err)
+
+	s.discard()
+	aside := s.path + ".corrupt"
+	if err := os.Rename(s.path, aside); err == nil {
+		log.Printf("Moved the unreadable database to %s", aside)
+	}
+	for n := 1; n <= stateBackups; n++ {
+		backup := backupPath(s.path, n)
+		if copyErr := copyFile(backup, s.path); copyErr != nil {
+			continue
+		}
+		doc, backupErr := s.load()
+		if backupErr != nil {
+			log.Printf("Backup %s is unreadable too: %v", backup, backupErr)
+			s.discard()
+			os.Remove(s.path)
+			continue
+		}
+		log.Printf("Restored download state from %s", backup)
+		return doc, nil
+	}
+	return nil, fmt.Errorf("%s: %w (and no readable backup)", s.path, err)
+}
+
+func (s *boltStore) load() (*stateDocument, error) {
+	doc := &stateDocument{Version: stateVersion}
+	err := s.view(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if meta == nil {
+			return nil
+		}
+		if v := meta.Get(keyVersion); v != nil {
+			version, err := strconv.Atoi(string(v))
+			if err != nil || version < 0 {
+				return fmt.Errorf("%w: bad version %q", errCorruptState, v)
+			}
+			doc.Version = version
+			if version > stateVersion {
+				return fmt.Errorf("database is version %d, %w (%d)", version, errNewerState, stateVersion)
+			}
+		}
+		if v := meta.Get(keySettings); v != nil {
+			doc.Settings = DefaultSettings()
+			if err := json.Unmarshal(v, doc.Settings); err != nil {
+				return fmt.Errorf("%w: settings: %v", errCorruptState, err)
+			}
+		}
+
+		var err error
+		if doc.Downloads, err = readTorrents(tx, bucketDownloads); err != nil {
+			return err
+		}
+		doc.Library, err = readTorrents(tx, bucketLibrary)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if doc.Version < stateVersion {
+		return upgradeDocument(doc)
+	}
+	return doc, nil
+}
+
+// upgradeDocument runs the state migrations on a document read from the
+// database. The next save writes it back in the current version.
+func upgradeDocument(doc *stateDocument) (*stateDocument, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if err := upgradeState(raw, doc.Version); err != nil {
+		return nil, err
+	}
+	if data, err = json.Marshal(raw); err != nil {
+		return nil, err
+	}
+	var upgraded stateDocument
+	if err := json.Unmarshal(data, &upgraded); err != nil {
+		return nil, err
+	}
+	log.Printf("Migrated download state from version %d to %d", doc.Version, stateVersion)
+	return &upgraded, nil
+}
+
+// readTorrents reads a section back in the order it was saved in, which is
+// kept as a list of keys alongside the items.
+func readTorrents(tx *bolt.Tx, name []byte) ([]Torrent, error) {
+	meta, bucket := tx.Bucket(bucketMeta), tx.Bucket(name)
+	if bucket == nil {
+		return nil, nil
+	}
+
+	var order []string
+	if err := json.Unmarshal(meta.Get(orderKey(name)), &order); err != nil {
+		return nil, fmt.Errorf("%w: %s order: %v", errCorruptState, name, err)
+	}
+	torrents := make([]Torrent, 0, len(order))
+	for _, key := range order {
+		v := bucket.Get([]byte(key))
+		if v == nil {
+			continue
+		}
+		var t Torrent
+		if err := json.Unmarshal(v, &t); err != nil {
+			return nil, fmt.Errorf("%w: %s %s: %v", errCorruptState, name, key, err)
+		}
+		torrents = append(torrents, t)
+	}
+	return torrents, nil
+}
+
+func orderKey(name []byte) []byte {
+	return append([]byte("order:"), name...)
+}
+
+func (s *boltStore) Save(doc *stateDocument) error {
+	if rotateBackups(s.path) {
+		err := s.view(func(tx *bolt.Tx) error {
+			return tx.CopyFile(backupPath(s.path, 1), 0644)
+		})
+		if err != nil {
+			log.Printf("Couldn't back up download state: %v", err)
+		}
+	}
+
+	err := s.update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		if err := meta.Put(keyVersion, []byte(strconv.Itoa(stateVersion))); err != nil {
+			return err
+		}
+		if doc.Settings != nil {
+			settings, err := json.Marshal(doc.Settings)
+			if err != nil {
+				return err
+			}
+			if err := meta.Put(keySettings, settings); err != nil {
+				return err
+			}
+		}
+
+		if err := writeTorrents(tx, bucketDownloads, doc.Downloads); err != nil {
+			return err
+		}
+		return writeTorrents(tx, bucketLibrary, doc.Library)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scheduleSnapshot()
+	return nil
+}
+
+// writeTorrents puts the items that changed, deletes the ones that are gone
+// and records their order.
+func writeTorrents(tx *bolt.Tx, name []byte, torrents []Torrent) error {
+	bucket, err := tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+	order := make([]string, 0, len(torrents))
+	for i, t := range torrents {
+		key := t.InfoHash
+		if key == "" || keep[key] {
+			key = fmt.Sprintf("%s#%d", t.InfoHash, i)
+		}
+		keep[key] = true
+		order = append(order, key)
+
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(bucket.Get([]byte(key)), data) {
+			continue
+		}
+		if err := bucket.Put([]byte(key), data); err != nil {
+			return err
+		}
+	}
+
+	var gone [][]byte
+	bucket.ForEach(func(k, _ []byte) error {
+		if !keep[string(k)] {
+			gone = append(gone, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	for _, k := range gone {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketMeta).Put(orderKey(name), data)
+}
+
+func (s *boltStore) AddSearch(query string) error {
+	return s.addSearch(SearchEntry{At: time.Now(), Query: query})
+}
+
+func (s *boltStore) addSearch(entry SearchEntry) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketSearches)
+		if err != nil {
+			return err
+		}
+		seq, _ := bucket.NextSequence()
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(binary.BigEndian.AppendUint64(nil, seq), data); err != nil {
+			return err
+		}
+
+		var old [][]byte
+		c := bucket.Cursor()
+		n := 0
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			if n++; n > searchHistorySize {
+				old = append(old, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range old {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Searches returns the most recent searches first.
+func (s *boltStore) Searches(limit int) ([]SearchEntry, error) {
+	var searches []SearchEntry
+	err := s.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketSearches)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil && len(searches) < limit; k, v = c.Prev() {
+			var entry SearchEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			searches = append(searches, entry)
+		}
+		return nil
+	})
+	return searches, err
+}
+
+// Events are keyed by time so reading them from a date on is a seek.
+func eventKey(at time.Time, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, uint64(at.UnixNano())), seq)
+}
+
+func (s *boltStore) AddEvent(e Event) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketEvents)
+		if err != nil {
+			return err
+		}
+		seq, _ := bucket.NextSequence()
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(eventKey(e.At, seq), data)
+	})
+}
+
+func (s *boltStore) Events(since time.Time) ([]Event, error) {
+	var events []Event
+	err := s.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketEvents)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		k, v := c.First()
+		if since.After(time.Unix(0, 0)) {
+			k, v = c.Seek(eventKey(since, 0))
+		}
+		for ; k != nil; k, v = c.Next() {
+			var e Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			events = append(events, e)
+		}
+		return nil
+	})
+	return events, err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// jsonStore keeps everything in one JSON document that is rewritten on
+// every save.
+type jsonStore struct {
+	path     string
+	readOnly bool
+	searches []SearchEntry
+	events   []Event
+	last     *stateDocument
+}
+
+func (s *jsonStore) Path() string {
+	return s.path
+}
+
+func (s *jsonStore) Close() error {
+	return nil
+}
+
+func (s *jsonStore) Load() (*stateDocument, error) {
+	doc, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	s.searches, s.events = doc.Searches, doc.Events
+	s.last = doc
+	return doc, nil
+}
+
+func (s *jsonStore) Save(doc *stateDocument) error {
+	full := *doc
+	full.Searches, full.Events = s.searches, s.events
+
+	data, err := json.MarshalIndent(full, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := s.write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.last = doc
+	return nil
+}
+
+func (s *jsonStore) resave() error {
+	if s.last == nil {
+		s.last = &stateDocument{Version: stateVersion}
+	}
+	return s.Save(s.last)
+}
+
+func (s *jsonStore) AddSearch(query string) error {
+	s.searches = append(s.searches, SearchEntry{At: time.Now(), Query: query})
+	if len(s.searches) > searchHistorySize {
+		s.searches = s.searches[len(s.searches)-searchHistorySize:]
+	}
+	return s.resave()
+}
+
+func (s *jsonStore) Searches(limit int) ([]SearchEntry, error) {
+	var searches []SearchEntry
+	for i := len(s.searches) - 1; i >= 0 && len(searches) < limit; i-- {
+		searches = append(searches, s.searches[i])
+	}
+	return searches, nil
+}
+
+func (s *jsonStore) AddEvent(e Event) error {
+	s.events = append(s.events, e)
+	return s.resave()
+}
+
+func (s *jsonStore) Events(since time.Time) ([]Event, error) {
+	var events []Event
+	for _, e := range s.events {
+		if !e.At.Before(since) {
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	return events, nil
+}
+
+// write replaces the state file without ever leaving a partial one
+// behind: the data goes to a temporary file that is synced and then renamed
+// over the old one.
+func (s *jsonStore) write(data []byte) error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".downloading-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if rotateBackups(s.path) {
+		if err := os.Link(s.path, backupPath(s.path, 1)); err != nil {
+			log.Printf("Couldn't back up download state: %v", err)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// read decodes the state file, falling back to the newest backup that still
+// decodes when it is corrupt. The corrupt file is kept aside rather than
+// overwritten by the next save. A read-only instance leaves the files alone.
+func (s *jsonStore) read() (*stateDocument, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		log.Println("Download state file does not exist. Starting fresh.")
+		return &stateDocument{Version: stateVersion}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := s.decode(s.path, data)
+	if err == nil || errors.Is(err, errNewerState) {
+		return doc, err
+	}
+	log.Printf("Download state is unreadable: %v", err)
+
+	if !s.readOnly {
+		corrupt := s.path + ".corrupt"
+		if err := os.Rename(s.path, corrupt); err == nil {
+			log.Printf("Moved the unreadable state file to %s", corrupt)
+		}
+	}
+
+	for n := 1; n <= stateBackups; n++ {
+		path := backupPath(s.path, n)
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			continue
+		}
+		doc, backupErr := s.decode(path, data)
+		if backupErr != nil {
+			log.Printf("Backup %s is unreadable too: %v", path, backupErr)
+			continue
+		}
+		log.Printf("Restored download state from %s", path)
+		return doc, nil
+	}
+	return nil, fmt.Errorf("%s: %w (and no readable backup)", s.path, err)
+}
+
+// decode decodes data read from path, keeping a copy of files that need
+// migrating before they get overwritten in the new format.
+func (s *jsonStore) decode(path string, data []byte) (*stateDocument, error) {
+	doc, from, err := decodeState(data)
+	if err != nil {
+		return nil, err
+	}
+	if from < stateVersion && !s.readOnly {
+		backup := fmt.Sprintf("%s.v%d.bak", s.path, from)
+		if err := os.WriteFile(backup, data, 0644); err != nil {
+			return nil, fmt.Errorf("backing up state before migration: %w", err)
+		}
+		log.Printf("Migrated %s from version %d to %d, old file kept at %s", path, from, stateVersion, backup)
+	}
+	return doc, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func testDocument() *stateDocument {
+	return &stateDocument{
+		Version:   stateVersion,
+		Downloads: []Torrent{{InfoHash: "A", Name: "Downloading", DownloadStatus: "pending"}},
+		Library:   []Torrent{{InfoHash: "B", Name: "Stored", DownloadStatus: "Stored"}},
+	}
+}
+
+// newBoltStore returns a store in a temporary directory that is closed when
+// the test ends.
+func newBoltStore(t *testing.T, path string) *boltStore {
+	t.Helper()
+	s := &boltStore{path: path}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBoltStoreRoundTrip(t *testing.T) {
+	s := newBoltStore(t, filepath.Join(t.TempDir(), "sailor.db"))
+	if err := s.Save(testDocument()); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(doc.Downloads) != "Downloading" || names(doc.Library) != "Stored" {
+		t.Errorf("loaded %q and %q", names(doc.Downloads), names(doc.Library))
+	}
+}
+
+func TestBoltStoreLeavesNewerDatabase(t *testing.T) {
+	s := newBoltStore(t, filepath.Join(t.TempDir(), "sailor.db"))
+	if err := s.Save(testDocument()); err != nil {
+		t.Fatal(err)
+	}
+	err := s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMeta).Put(keyVersion, []byte(strconv.Itoa(stateVersion+1)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Load(); !errors.Is(err, errNewerState) {
+		t.Errorf("Load = %v, want errNewerState", err)
+	}
+	if _, err := os.Stat(s.path); err != nil {
+		t.Errorf("newer database was moved away: %v", err)
+	}
+	if _, err := os.Stat(s.path + ".corrupt"); err == nil {
+		t.Error("newer database was treated as corrupt")
+	}
+}
+
+func TestBoltStoreRestoresBackup(t *testing.T) {
+	dir := t.TempDir()
+	s := newBoltStore(t, filepath.Join(dir, "sailor.db"))
+	good := newBoltStore(t, backupPath(s.path, 1))
+	if err := good.Save(testDocument()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.path, []byte("this is not a bolt database, just some bytes to fill a page"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load = %v, want the backup", err)
+	}
+	if names(doc.Library) != "Stored" {
+		t.Errorf("restored library = %q", names(doc.Library))
+	}
+	if _, err := os.Stat(s.path + ".corrupt"); err != nil {
+		t.Errorf("corrupt database wasn't kept aside: %v", err)
+	}
+}
+
+func TestBoltStoreKeepsDatabaseOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sailor.db")
+	owner := newBoltStore(t, path)
+	if err := owner.Save(testDocument()); err != nil {
+		t.Fatal(err)
+	}
+	db := owner.db
+	if err := owner.AddEvent(Event{Kind: eventAdded}); err != nil {
+		t.Fatal(err)
+	}
+	if db == nil || owner.db != db {
+		t.Fatal("the store opened the database again for another operation")
+	}
+
+	// The owner's lock keeps the database itself from a read-only
+	// instance, which reads the snapshot instead.
+	reader := &boltStore{path: path, readOnly: true}
+	for deadline := time.Now().Add(5 * time.Second); reader.Path() == path && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reader.Path() != snapshotPath(path) {
+		t.Fatal("no snapshot was written for read-only instances")
+	}
+	doc, err := reader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(doc.Library) != "Stored" {
+		t.Errorf("read-only instance loaded %q", names(doc.Library))
+	}
+
+	if err := owner.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := reader.Events(time.Time{}); err != nil || len(events) != 1 {
+		t.Errorf("events after Close = %+v, %v, want the one event", events, err)
+	}
+}
+
+func TestJSONStoreLeavesNewerFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".downloading.json")
+	if err := os.WriteFile(path, []byte(`{"version": 99, "downloads": [], "library": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &jsonStore{path: path}
+	if _, err := s.Load(); !errors.Is(err, errNewerState) {
+		t.Errorf("Load = %v, want errNewerState", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("newer state file was moved away: %v", err)
+	}
+}
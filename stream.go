@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type StreamSettings struct {
+	// Addr is where the local streaming server listens. Port 0 picks a free
+	// one.
+	Addr string `json:"addr" toml:"addr"`
+}
+
+func DefaultStreamSettings() StreamSettings {
+	return StreamSettings{
+		Addr: "127.0.0.1:0",
+	}
+}
+
+type aria2File struct {
+	Index           string `json:"index"`
+	Path            string `json:"path"`
+	Length          string `json:"length"`
+	CompletedLength string `json:"completedLength"`
+}
+
+type aria2PieceStatus struct {
+	PieceLength string `json:"pieceLength"`
+	NumPieces   string `json:"numPieces"`
+	Bitfield    string `json:"bitfield"`
+}
+
+// hasPieces reports whether every piece from first to last is complete in
+// aria2's hex bitfield, where the high bit of each nibble is the lowest
+// piece.
+func (s aria2PieceStatus) hasPieces(first, last int64) bool {
+	for i := first; i <= last; i++ {
+		if i/4 >= int64(len(s.Bitfield)) {
+			return false
+		}
+		nibble, err := strconv.ParseUint(s.Bitfield[i/4:i/4+1], 16, 8)
+		if err != nil || nibble&(8>>(i%4)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// streamSource is one file of a torrent that is still downloading. offset
+// is where the file starts in the torrent's concatenated data, which is what
+// pieces are counted in.
+type streamSource struct {
+	port        int
+	gid         string
+	path        string
+	offset      int64
+	length      int64
+	pieceLength int64
+}
+
+func (s *streamSource) waitForRange(ctx context.Context, start, end int64) error {
+	first := (s.offset + start) / s.pieceLength
+	last := (s.offset + end - 1) / s.pieceLength
+
+	for {
+		var status aria2PieceStatus
+		err := callAria2(s.port, "aria2.tellStatus", []any{s.gid, []string{"bitfield"}}, &status)
+		if err != nil {
+			return err
+		}
+		if status.hasPieces(first, last) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// pieceReader is an io.ReadSeeker over a partially downloaded file that
+// blocks reads until the pieces they cover have been downloaded. Reads never
+// cross a piece boundary so playback only ever waits for the next piece.
+type pieceReader struct {
+	ctx    context.Context
+	source *streamSource
+	file   *os.File
+	pos    int64
+}
+
+func (r *pieceReader) Read(p []byte) (int, error) {
+	if r.pos >= r.source.length {
+		return 0, io.EOF
+	}
+
+	pieceEnd := ((r.source.offset+r.pos)/r.source.pieceLength+1)*r.source.pieceLength - r.source.offset
+	end := min(r.pos+int64(len(p)), pieceEnd, r.source.length)
+	if err := r.source.waitForRange(r.ctx, r.pos, end); err != nil {
+		return 0, err
+	}
+
+	n, err := r.file.ReadAt(p[:end-r.pos], r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *pieceReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.source.length
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of file")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+type streamServer struct {
+	mu       sync.Mutex
+	listener net.Listener
+	server   *http.Server
+	sources  map[string]*streamSource
+}
+
+func startStreamServer(addr string) (*streamServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &streamServer{
+		listener: listener,
+		sources:  make(map[string]*streamSource),
+	}
+	s.server = &http.Server{Handler: s}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Stream server stopped: %v", err)
+		}
+	}()
+	log.Printf("Stream server listening on %s", listener.Addr())
+	return s, nil
+}
+
+// Stop closes the listener and drops open streams.
+func (s *streamServer) Stop() {
+	if err := s.server.Close(); err != nil {
+		log.Printf("Couldn't stop stream server: %v", err)
+	}
+}
+
+func (s *streamServer) URL(infoHash string) string {
+	return fmt.Sprintf("http://%s/stream/%s", s.listener.Addr(), infoHash)
+}
+
+func (s *streamServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	infoHash := strings.TrimPrefix(r.URL.Path, "/stream/")
+
+	s.mu.Lock()
+	source, ok := s.sources[infoHash]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(source.path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer file.Close()
+
+	reader := &pieceReader{ctx: r.Context(), source: source, file: file}
+	http.ServeContent(w, r, filepath.Base(source.path), time.Time{}, reader)
+}
+
+// streamRestartTimeout is how long a download restarted for streaming gets
+// to come back with its metadata.
+const streamRestartTimeout = 30 * time.Second
+
+// streamStoppedMsg reports that a download's aria2c, process group pgid,
+// was stopped to start it again for streaming.
+type streamStoppedMsg struct {
+	infoHash string
+	pgid     int
+}
+
+type streamReadyMsg struct {
+	infoHash string
+	port     int
+	gid      string
+	err      error
+}
+
+// streamDownload serves a running download's main video over the local
+// stream server and opens the player on it. aria2 only picks pieces in order
+// and fetches the start and end of the file first when it is started that
+// way, so a download that wasn't is restarted for streaming first.
+func (m *model) streamDownload(t *Torrent) (tea.Cmd, error) {
+	if t.DownloadStatus != "Downloading" {
+		return nil, errors.New("only running downloads can be streamed")
+	}
+	if t.Stream {
+		return m.serveStream(t)
+	}
+
+	m.notice = "Restarting " + t.Name + " for streaming…"
+	t.Stream = true
+	t.DownloadStatus = "Restarting"
+	running := *t
+	return func() tea.Msg {
+		stopAria2c(&running)
+		return streamStoppedMsg{infoHash: running.InfoHash, pgid: running.PGID}
+	}, nil
+}
+
+// stopAria2c saves t's session and shuts its aria2c down, killing it when
+// it doesn't go in time. It runs off the UI goroutine on a copy of the
+// entry.
+func stopAria2c(t *Torrent) {
+	if err := callAria2(t.Port, "aria2.saveSession", nil, nil); err != nil {
+		log.Printf("Couldn't save aria2 session for %s: %v", t.Name, err)
+	}
+	if err := callAria2(t.Port, "aria2.shutdown", nil, nil); err != nil {
+		t.killAria2c(syscall.SIGTERM)
+	}
+	for deadline := time.Now().Add(10 * time.Second); processGroupAlive(t.PGID) && time.Now().Before(deadline); {
+		time.Sleep(200 * time.Millisecond)
+	}
+	if processGroupAlive(t.PGID) {
+		t.killAria2c(syscall.SIGKILL)
+		time.Sleep(time.Second)
+	}
+}
+
+// restartForStream starts a download stopped for streaming again with the
+// streaming options, then waits in the background for it to be active.
+func (m *model) restartForStream(msg streamStoppedMsg) tea.Cmd {
+	t := m.findDownload(msg.infoHash)
+	if t == nil || t.DownloadStatus != "Restarting" || (t.PGID != 0 && t.PGID != msg.pgid) {
+		m.notice = "Can't stream: the download was stopped"
+		return nil
+	}
+	t.PGID, t.Port = 0, 0
+	t.DownloadStatus = "pending"
+	m.startPending()
+	if t.DownloadStatus != "Downloading" {
+		m.notice = "Can't stream: the download didn't restart"
+		return nil
+	}
+
+	infoHash, port := t.InfoHash, t.Port
+	return func() tea.Msg {
+		for deadline := time.Now().Add(streamRestartTimeout); time.Now().Before(deadline); time.Sleep(time.Second) {
+			if downloads, err := FetchDownloadInfo(port); err == nil && len(downloads) > 0 {
+				return streamReadyMsg{infoHash: infoHash, port: port, gid: downloads[0].GID}
+			}
+		}
+		return streamReadyMsg{infoHash: infoHash, port: port, err: errors.New("timed out waiting for the download to restart")}
+	}
+}
+
+func (m *model) streamReady(msg streamReadyMsg) tea.Cmd {
+	t := m.findDownload(msg.infoHash)
+	if msg.err == nil && (t == nil || t.Port != msg.port || t.DownloadStatus != "Downloading") {
+		msg.err = errors.New("the download is gone")
+	}
+	if msg.err != nil {
+		m.notice = "Can't stream: " + msg.err.Error()
+		return nil
+	}
+	t.GID = msg.gid
+	cmd, err := m.serveStream(t)
+	if err != nil {
+		m.notice = "Can't stream: " + err.Error()
+	}
+	return cmd
+}
+
+// serveStream puts a download started for streaming on the stream server
+// and plays it.
+func (m *model) serveStream(t *Torrent) (tea.Cmd, error) {
+	gid := t.GID
+	if gid == "" {
+		downloads, err := FetchDownloadInfo(t.Port)
+		if err != nil || len(downloads) == 0 {
+			return nil, fmt.Errorf("no active download to stream: %v", err)
+		}
+		gid = downloads[0].GID
+	}
+
+	var files []aria2File
+	if err := callAria2(t.Port, "aria2.getFiles", []any{gid}, &files); err != nil {
+		return nil, err
+	}
+	var pieces aria2PieceStatus
+	if err := callAria2(t.Port, "aria2.tellStatus", []any{gid, []string{"pieceLength", "numPieces"}}, &pieces); err != nil {
+		return nil, err
+	}
+	pieceLength, _ := strconv.ParseInt(pieces.PieceLength, 10, 64)
+	if pieceLength <= 0 {
+		return nil, errors.New("torrent metadata isn't available yet")
+	}
+
+	var source *streamSource
+	var offset int64
+	for _, f := range files {
+		length, _ := strconv.ParseInt(f.Length, 10, 64)
+		if videoExts[strings.ToLower(filepath.Ext(f.Path))] && (source == nil || length > source.length) {
+			source = &streamSource{
+				port:        t.Port,
+				gid:         gid,
+				path:        f.Path,
+				offset:      offset,
+				length:      length,
+				pieceLength: pieceLength,
+			}
+		}
+		offset += length
+	}
+	if source == nil {
+		return nil, errors.New("no video file in this torrent")
+	}
+
+	if m.streamServer == nil {
+		server, err := startStreamServer(m.settings.Stream.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("starting stream server: %w", err)
+		}
+		m.streamServer = server
+	}
+	m.streamServer.mu.Lock()
+	m.streamServer.sources[t.InfoHash] = source
+	m.streamServer.mu.Unlock()
+
+	url := m.streamServer.URL(t.InfoHash)
+	log.Printf("Streaming %s at %s", source.path, url)
+	return m.play(t.InfoHash, url), nil
+}
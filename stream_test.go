@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHasPieces(t *testing.T) {
+	tests := []struct {
+		bitfield    string
+		first, last int64
+		want        bool
+	}{
+		{"f0", 0, 3, true},
+		{"f0", 3, 4, false},
+		{"f0", 4, 4, false},
+		{"a", 0, 0, true},
+		{"a", 1, 1, false},
+		{"a", 2, 2, true},
+		{"a", 0, 2, false},
+		{"0f", 4, 7, true},
+		{"0F", 7, 7, true},
+		{"ff", 7, 8, false},
+		{"", 0, 0, false},
+		{"zz", 0, 0, false},
+	}
+	for _, tt := range tests {
+		s := aria2PieceStatus{Bitfield: tt.bitfield}
+		if got := s.hasPieces(tt.first, tt.last); got != tt.want {
+			t.Errorf("hasPieces(%q, %d, %d) = %v, want %v", tt.bitfield, tt.first, tt.last, got, tt.want)
+		}
+	}
+}
+
+// fakeBitfield answers aria2.tellStatus with a bitfield the test can change.
+func fakeBitfield(t *testing.T, bitfield string) (int, func(string)) {
+	t.Helper()
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": "1", "result": aria2PieceStatus{Bitfield: bitfield}})
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port, func(b string) {
+		mu.Lock()
+		defer mu.Unlock()
+		bitfield = b
+	}
+}
+
+func TestStreamServesDownloadedRanges(t *testing.T) {
+	// The file starts at piece 1 of the torrent and only pieces 1 and 2, its
+	// first eight bytes, are in.
+	port, setBitfield := fakeBitfield(t, "6")
+	path := filepath.Join(t.TempDir(), "episode.mkv")
+	content := "0123456789abcdef"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := startStreamServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	s.sources["A"] = &streamSource{port: port, gid: "1", path: path, offset: 4, length: int64(len(content)), pieceLength: 4}
+
+	get := func(ctx context.Context, from, to int) (*http.Response, string, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.URL("A"), nil)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp, string(body), err
+	}
+
+	resp, body, err := get(context.Background(), 2, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPartialContent || body != content[2:7] {
+		t.Errorf("downloaded range = %d %q, want 206 %q", resp.StatusCode, body, content[2:7])
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 2-6/16" {
+		t.Errorf("Content-Range = %q", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, body, err := get(ctx, 8, 11); err == nil {
+		t.Errorf("a range past the downloaded pieces was served: %q", body)
+	}
+
+	setBitfield("7f")
+	if _, body, err := get(context.Background(), 8, 15); err != nil || body != content[8:] {
+		t.Errorf("range after its pieces came in = %q, %v, want %q", body, err, content[8:])
+	}
+
+	resp, err = http.Get(s.URL("unknown"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown stream got %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const tailBufferSize = 4096
+
+var errAria2cMissing = errors.New("aria2c not found in PATH, install aria2 to download torrents")
+
+func checkAria2c() error {
+	if _, err := exec.LookPath("aria2c"); err != nil {
+		return errAria2cMissing
+	}
+	return nil
+}
+
+// tailBuffer keeps the last few KB written to it. aria2c's stderr goes into
+// one so a crash can be reported with aria2c's own words instead of just an
+// exit code.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (s *tailBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buf = append(s.buf, p...)
+	if len(s.buf) > tailBufferSize {
+		s.buf = s.buf[len(s.buf)-tailBufferSize:]
+	}
+	return len(p), nil
+}
+
+func (s *tailBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return string(s.buf)
+}
+
+func (s *tailBuffer) LastLine() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := bytes.Split(bytes.TrimSpace(s.buf), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(string(lines[i])); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+func describeExit(err error, stderr *tailBuffer) (int, string) {
+	code, msg := -1, err.Error()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+		msg = fmt.Sprintf("aria2c exited with code %d", code)
+	}
+	if line := stderr.LastLine(); line != "" {
+		msg += ": " + line
+	}
+	return code, msg
+}
+
+// dir is where t is downloaded to. Directories are named after the torrent
+// plus the start of its info hash, so torrents with the same name don't
+// share one.
+func (t *Torrent) dir() string {
+	root := downloadRoot
+	if t.Dir != "" {
+		root = t.Dir
+	}
+	switch {
+	case t.Untracked:
+		return filepath.Join(root, t.Name)
+	case t.DirName != "":
+		return filepath.Join(root, t.DirName)
+	}
+	return filepath.Join(root, sanitizeFileName(t.Name)+"-"+shortHash(t.InfoHash))
+}
+
+func shortHash(infoHash string) string {
+	return strings.ToLower(infoHash[:min(len(infoHash), 8)])
+}
+
+func (t *Torrent) sessionFile() string {
+	return filepath.Join(t.dir(), ".aria2.session")
+}
+
+func (m *model) findDownload(infoHash string) *Torrent {
+	for i := range m.Downloading {
+		if m.Downloading[i].InfoHash == infoHash {
+			return &m.Downloading[i]
+		}
+	}
+	return nil
+}
+
+// startAria2c launches aria2c for t in its own process group. When a session
+// file from an earlier run exists it is used instead of the magnet link so
+// aria2c picks up where it left off.
+func (m *model) startAria2c(t *Torrent) (*exec.Cmd, *tailBuffer, error) {
+	downloadDir := t.dir()
+	if err := os.MkdirAll(downloadDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("creating download directory: %w", err)
+	}
+
+	port, err := getFreePort()
+	if err != nil {
+		return nil, nil, fmt.Errorf("finding free port: %w", err)
+	}
+	t.Port = port
+
+	args := []string{
+		"--enable-rpc=true",
+		fmt.Sprintf("--rpc-listen-port=%d", t.Port),
+		"--rpc-secret=" + aria2SecretToken,
+		"--continue=true",
+		"--bt-save-metadata=true",
+		"--save-session=" + t.sessionFile(),
+		"--save-session-interval=30",
+		fmt.Sprintf("--bt-stop-timeout=%d", m.settings.Retry.StallTimeoutSeconds),
+		"--dir", downloadDir,
+	}
+	if t.Stream {
+		args = append(args, "--stream-piece-selector=inorder", "--bt-prioritize-piece=head,tail")
+	}
+	if metadata := metadataPath(downloadDir, t.InfoHash); t.Repair && fileExists(metadata) {
+		args = append(args, "--check-integrity=true", metadata)
+	} else if info, err := os.Stat(t.sessionFile()); err == nil && info.Size() > 0 {
+		args = append(args, "--input-file="+t.sessionFile())
+	} else {
+		args = append(args, CreateMagnetLink(t.InfoHash, t.Name))
+	}
+
+	cmd := exec.Command("aria2c", args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+
+	log.Printf("Running command: %s", strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("starting aria2c: %w", err)
+	}
+
+	t.PGID, err = syscall.Getpgid(cmd.Process.Pid)
+	if err != nil {
+		log.Printf("Cannot get process GID: %v", err)
+		t.PGID = cmd.Process.Pid
+	}
+	t.DownloadStatus = "Downloading"
+
+	return cmd, stderr, nil
+}
+
+// aria2cExitedMsg reports that the aria2c process group pgid, started for
+// infoHash, is gone.
+type aria2cExitedMsg struct {
+	infoHash string
+	pgid     int
+	err      error
+	stderr   *tailBuffer
+}
+
+// retryMsg starts a failed download again once its backoff is over.
+type retryMsg struct {
+	infoHash string
+}
+
+// supervise waits on aria2c and tells Update when it is gone. It runs in
+// its own goroutine and leaves the download entry alone.
+func (m *model) supervise(infoHash string, pgid int, wait func() error, stderr *tailBuffer) {
+	err := wait()
+	m.send(aria2cExitedMsg{infoHash: infoHash, pgid: pgid, err: err, stderr: stderr})
+}
+
+// aria2cExited handles aria2c dying, or stopping after the poller reported
+// that aria2 gave up on the download, and schedules a retry according to
+// the retry policy until the torrent is cancelled or runs out of attempts.
+func (m *model) aria2cExited(msg aria2cExitedMsg) tea.Cmd {
+	// Another aria2c may have been started for the download while this one
+	// ran, by a restart for streaming, and that one is not ours to judge.
+	t := m.findDownload(msg.infoHash)
+	if item := m.findLibraryItem(msg.infoHash); t == nil && item != nil && item.PGID == msg.pgid {
+		// A finished download's aria2c, stopped by finishProcessing.
+		item.PGID, item.Port = 0, 0
+		return nil
+	}
+	if m.quitting || t == nil || t.PGID != msg.pgid {
+		return nil
+	}
+	// The group is gone, and a later signal must not reach whatever reuses
+	// its id.
+	t.PGID, t.Port = 0, 0
+	switch t.DownloadStatus {
+	case "Downloading":
+		if msg.err == nil {
+			t.DownloadStatus = "Complete"
+			return nil
+		}
+		var code int
+		code, t.LastError = describeExit(msg.err, msg.stderr)
+		t.FailureReason = classifyFailure(code, t.LastError)
+	case "Errored":
+		// failDownload already recorded aria2's reason and stopped aria2c.
+	default:
+		return nil
+	}
+	log.Printf("%s failed (%s): %s", t.Name, t.FailureReason, t.LastError)
+
+	policy := m.settings.Retry
+	if !policy.ShouldRetry(t) {
+		t.DownloadStatus = "Failed"
+		m.recordEvent(eventFailed, *t)
+		return nil
+	}
+	t.Attempts++
+	t.DownloadStatus = "Restarting"
+
+	infoHash := t.InfoHash
+	return tea.Tick(policy.Backoff(t.Attempts), func(time.Time) tea.Msg {
+		return retryMsg{infoHash: infoHash}
+	})
+}
+
+func (m *model) retry(msg retryMsg) {
+	t := m.findDownload(msg.infoHash)
+	if m.quitting || t == nil || t.DownloadStatus != "Restarting" || t.PGID != 0 {
+		return
+	}
+	m.launch(t)
+}
+
+// launch starts aria2c for t and supervises it. A download whose aria2c
+// can't be started fails right away.
+func (m *model) launch(t *Torrent) {
+	cmd, stderr, err := m.startAria2c(t)
+	if err != nil {
+		t.LastError = err.Error()
+		t.FailureReason = failureUnknown
+		t.DownloadStatus = "Failed"
+		m.recordEvent(eventFailed, *t)
+		log.Printf("%s: %v", t.Name, err)
+		return
+	}
+	go m.supervise(t.InfoHash, t.PGID, cmd.Wait, stderr)
+}
+
+// failDownload records why aria2 stopped a download and shuts its aria2c
+// down so supervise can decide whether to retry.
+func (m *model) failDownload(t *Torrent, failed *aria2Status) {
+	code, _ := strconv.Atoi(failed.ErrorCode)
+	t.LastError = fmt.Sprintf("aria2 error %s: %s", failed.ErrorCode, failed.ErrorMessage)
+	t.FailureReason = classifyFailure(code, failed.ErrorMessage)
+	t.DownloadStatus = "Errored"
+
+	if err := callAria2(t.Port, "aria2.shutdown", nil, nil); err != nil {
+		t.killAria2c(syscall.SIGTERM)
+	}
+}
+
+// startPending launches aria2c for every download waiting to be started.
+func (m *model) startPending() {
+	for i := range m.Downloading {
+		t := &m.Downloading[i]
+		if t.DownloadStatus != "pending" {
+			continue
+		}
+
+		warning, err := m.preflightDiskSpace(t)
+		if err != nil {
+			t.LastError = err.Error()
+			t.FailureReason = failureDiskFull
+			t.DownloadStatus = "Failed"
+			m.recordEvent(eventFailed, *t)
+			log.Printf("Not starting %s: %v", t.Name, err)
+			continue
+		}
+		if warning != "" {
+			m.notice = warning
+			log.Println(warning)
+		}
+		m.launch(t)
+	}
+}
+
+// resumeDownloads reconnects to aria2c instances left running by an earlier
+// run and re-adds downloads saved as running or restarting whose aria2c
+// process group no longer exists. It runs from Init, before Update.
+func (m *model) resumeDownloads() {
+	if m.aria2Err != nil {
+		return
+	}
+	for i := range m.Downloading {
+		t := &m.Downloading[i]
+		switch t.DownloadStatus {
+		case "Downloading":
+			t.Detached = false
+			if t.ownsAria2c() {
+				go m.supervise(t.InfoHash, t.PGID, waitForGroup(t.PGID), nil)
+			} else {
+				log.Printf("No aria2c of ours left for %s (Port: %d, PGID: %d), restarting it", t.Name, t.Port, t.PGID)
+				t.PGID, t.Port = 0, 0
+				t.DownloadStatus = "pending"
+			}
+		case "Restarting", "Errored":
+			t.DownloadStatus = "pending"
+		case "Processing", "Extracting":
+			t.DownloadStatus = "Complete"
+		}
+	}
+	m.startPending()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func processGroupAlive(pgid int) bool {
+	if pgid <= 0 {
+		return false
+	}
+	return syscall.Kill(-pgid, 0) == nil
+}
+
+// ownsAria2c reports whether t's saved process group is still the aria2c
+// sailor started for it. Process group IDs are reused, so a group that merely
+// exists, say after a reboot, may belong to anything. aria2c leads its own
+// group, so its command line must be aria2c listening on t's port; without
+// /proc, the RPC must answer with sailor's secret.
+func (t *Torrent) ownsAria2c() bool {
+	if t.Port == 0 || !processGroupAlive(t.PGID) {
+		return false
+	}
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", t.PGID))
+	if err != nil {
+		return checkAria2cRPC(t.Port) == nil
+	}
+
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	if filepath.Base(args[0]) != "aria2c" {
+		return false
+	}
+	for _, arg := range args[1:] {
+		if arg == fmt.Sprintf("--rpc-listen-port=%d", t.Port) {
+			return true
+		}
+	}
+	return false
+}
+
+// killAria2c sends sig to t's aria2c process group, as long as it is still
+// the one sailor started.
+func (t *Torrent) killAria2c(sig syscall.Signal) {
+	if !t.ownsAria2c() {
+		log.Printf("Not signalling process group %d: it isn't %s's aria2c", t.PGID, t.Name)
+		return
+	}
+	if err := syscall.Kill(-t.PGID, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		log.Printf("couldn't kill process group %d: %v", t.PGID, err)
+	}
+}
+
+func (m *model) downloadStatusText(t Torrent) string {
+	switch t.DownloadStatus {
+	case "Downloading":
+		if t.Attempts > 0 {
+			return fmt.Sprintf("Downloading (retry %d, last: %s)", t.Attempts, t.FailureReason)
+		}
+	case "Extracting":
+		return fmt.Sprintf("Extracting %d%%", t.ExtractProgress)
+	case "Restarting", "Errored":
+		return fmt.Sprintf("Retry %d/%d (%s)", t.Attempts, m.settings.Retry.MaxAttempts, t.FailureReason)
+	case "Failed":
+		if t.FailureReason != "" {
+			return fmt.Sprintf("Failed (%s) after %d retries: %s", t.FailureReason, t.Attempts, t.LastError)
+		}
+	}
+	return t.DownloadStatus
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAria2cExited(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		pgid       int
+		err        error
+		wantStatus string
+		wantRetry  bool
+	}{
+		{"finished", "Downloading", 4242, nil, "Complete", false},
+		{"crashed", "Downloading", 4242, errors.New("signal: killed"), "Restarting", true},
+		{"disk full", "Downloading", 4242, errors.New("No space left on device"), "Failed", false},
+		{"errored", "Errored", 4242, errors.New("exit status 3"), "Restarting", true},
+		{"cancelled", "Cancelled", 4242, errors.New("signal: terminated"), "Cancelled", false},
+		{"replaced", "Downloading", 1111, errors.New("signal: terminated"), "Downloading", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(DefaultSettings())
+			m.Downloading = []Torrent{{InfoHash: "A", Name: "Show", DownloadStatus: tt.status, PGID: 4242, Port: 6801}}
+
+			cmd := m.aria2cExited(aria2cExitedMsg{infoHash: "A", pgid: tt.pgid, err: tt.err})
+			d := m.Downloading[0]
+			if d.DownloadStatus != tt.wantStatus {
+				t.Errorf("status = %q, want %q", d.DownloadStatus, tt.wantStatus)
+			}
+			if (cmd != nil) != tt.wantRetry {
+				t.Errorf("retry scheduled = %v, want %v", cmd != nil, tt.wantRetry)
+			}
+			if ours := tt.pgid == 4242; ours != (d.PGID == 0) {
+				t.Errorf("process group = %d after the exit of %d", d.PGID, tt.pgid)
+			}
+		})
+	}
+}
+
+func TestDownloadInfoIgnoresOtherAria2c(t *testing.T) {
+	m := New(DefaultSettings())
+	m.Downloading = []Torrent{{InfoHash: "A", Name: "Show", DownloadStatus: "Downloading", Port: 6801, Size: "1.00 KB"}}
+
+	stale := &aria2Status{GID: "old", CompletedLength: "1024", DownloadSpeed: "10"}
+	m.downloadInfo(downloadInfoMsg{infoHash: "A", port: 6800, info: stale})
+	if m.Downloading[0].GID != "" {
+		t.Errorf("an answer from a replaced aria2c was applied: %+v", m.Downloading[0])
+	}
+
+	m.downloadInfo(downloadInfoMsg{infoHash: "A", port: 6801, info: &aria2Status{GID: "new", CompletedLength: "1024"}})
+	if d := m.Downloading[0]; d.GID != "new" || d.DownloadStatus != "Complete" {
+		t.Errorf("download = %+v, want the answer applied and the download complete", d)
+	}
+}
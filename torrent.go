@@ -9,17 +9,15 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"syscall"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-var trackers = []string{
+var defaultTrackers = []string{
 	"udp://tracker.openbittorrent.com:80",
 	"udp://tracker.opentrackr.org:1337/announce",
 	"udp://9.rarbg.to:2920/announce",
@@ -33,106 +31,306 @@ var trackers = []string{
 	"udp://tracker.moeking.me:6969/announce",
 }
 
+// trackers are the ones from the config.
+var trackers = defaultTrackers
+
 const (
 	aria2URL         = "http://localhost:%d/jsonrpc"
 	aria2SecretToken = "zivotjelijp12345"
 )
 
 type Request struct {
-	JSONRPC string   `json:"jsonrpc"`
-	ID      string   `json:"id"`
-	Method  string   `json:"method"`
-	Params  []string `json:"params"`
+	JSONRPC string `json:"jsonrpc"`
+	ID      string `json:"id"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
 }
 
 type Response struct {
-	Result []Torrent `json:"result"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("aria2 error %d: %s", e.Code, e.Message)
+}
+
+var rpcClient = &http.Client{
+	Timeout: 5 * time.Second,
 }
 
+// Torrent is a search result, download or library item. Fields tagged "-"
+// only mean something while sailor is running and are not saved.
 type Torrent struct {
-	GID            string `json:"gid,omitempty"`
-	DownloadStatus string
-	PGID           int    `json:"PGID"`
-	Status         string `json:"status"`
+	GID            string `json:"-"`
+	DownloadStatus string `json:"download_status"`
+	PGID           int    `json:"-"`
+	Status         string `json:"-"`
 	Size           string `json:"size"`
-	CompletedSize  string `json:"completedLength"`
-	DownloadSpeed  string `json:"downloadSpeed"`
-	Time           string
-	InfoHash       string `json:"info_hash"`
-	Name           string `json:"name"`
-	Leechers       int    `json:"leechers"`
-	Seeders        int    `json:"seeders"`
-	NumFiles       int    `json:"num_files"`
-	Port           int
-}
-
-func (m *model) cancelDownload() {
-	t := &m.Downloading[m.selectedID]
-	pgid := strconv.Itoa(t.PGID)
-	cmd := exec.Command("pkill", "-g", pgid)
+	Bytes          int64  `json:"bytes"`
+	CompletedSize  string `json:"-"`
+	DownloadSpeed  string `json:"-"`
+	// DownloadRate and UploadRate are the aria2c's overall speeds in bytes
+	// per second, from its global stats as of the last poll. They are 0
+	// whenever that poll found nothing active.
+	DownloadRate int64  `json:"-"`
+	UploadRate   int64  `json:"-"`
+	Time         string `json:"-"`
+	InfoHash     string `json:"info_hash"`
+	Name         string `json:"name"`
+	Leechers     int    `json:"-"`
+	Seeders      int    `json:"-"`
+	NumFiles     int    `json:"num_files"`
+	Category     string `json:"category,omitempty"`
+	// Dir is the directory the download was sent to by its category. Empty
+	// means the download root.
+	Dir string `json:"dir,omitempty"`
+	// DirName overrides the directory name under Dir, for torrents from
+	// before directories got the info hash in their name.
+	DirName         string         `json:"dir_name,omitempty"`
+	Port            int            `json:"-"`
+	Reconnect       *reconnectInfo `json:"reconnect,omitempty"`
+	Attempts        int            `json:"attempts,omitempty"`
+	FailureReason   string         `json:"failure_reason,omitempty"`
+	LastError       string         `json:"last_error,omitempty"`
+	Detached        bool           `json:"detached,omitempty"`
+	SpacePaused     bool           `json:"-"`
+	Files           []string       `json:"files,omitempty"`
+	ExtractProgress int            `json:"-"`
+	ExtractedFiles  []string       `json:"extracted_files,omitempty"`
+	HookResults     []HookResult   `json:"hook_results,omitempty"`
+	Missing         bool           `json:"missing,omitempty"`
+	Untracked       bool           `json:"untracked,omitempty"`
+	Path            string         `json:"path,omitempty"`
+	Magnet          string         `json:"magnet,omitempty"`
+	Provider        string         `json:"provider,omitempty"`
+	Query           string         `json:"query,omitempty"`
+	AddedAt         time.Time      `json:"added_at"`
+	CompletedAt     time.Time      `json:"completed_at"`
+	Watched         bool           `json:"watched,omitempty"`
+	WatchedFiles    []string       `json:"watched_files,omitempty"`
+	Verify          *VerifyResult  `json:"verify,omitempty"`
+	Repair          bool           `json:"repair,omitempty"`
+	// Stream starts aria2c fetching pieces in order, for streaming.
+	Stream bool `json:"stream,omitempty"`
+}
 
-	if err := cmd.Start(); err != nil {
-		log.Printf("couldn't execute command: %v", err)
-	}
+// reconnectInfo is where a running download's aria2c can be found again
+// after a restart.
+type reconnectInfo struct {
+	Port int `json:"port"`
+	PGID int `json:"pgid"`
+}
+
+// aria2Status is the part of aria2's tellActive/tellStopped answer sailor
+// looks at.
+type aria2Status struct {
+	GID             string `json:"gid"`
+	Status          string `json:"status"`
+	TotalLength     string `json:"totalLength"`
+	CompletedLength string `json:"completedLength"`
+	DownloadSpeed   string `json:"downloadSpeed"`
+	ErrorCode       string `json:"errorCode"`
+	ErrorMessage    string `json:"errorMessage"`
+}
 
-	if err := cmd.Wait(); err != nil {
-		log.Printf("couldn't kill process: %v", err)
+// stopDownload kills the selected download's aria2c so its entry and files
+// can be removed. Only a running download has an aria2c to kill.
+func (m *model) stopDownload() {
+	t := &m.Downloading[m.selectedID]
+	running := t.DownloadStatus == "Downloading" && t.PGID != 0
+	t.DownloadStatus = "Cancelled"
+	if !running {
+		return
 	}
+	pgid := t.PGID
+	t.killAria2c(syscall.SIGTERM)
+	for i := 0; i < 20 && processGroupAlive(pgid); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
 
-	dir := filepath.Join(homeDir, "Downloads", "Sailor", sanitizeFileName(t.Name))
+// What removeItem does with the data of the entry it removes.
+const (
+	keepData   = "keep"
+	trashData  = "trash"
+	deleteData = "delete"
+)
 
-	cmd = exec.Command("rm", "-rf", dir)
+// removeSelected removes the selected download or library entry. Downloads
+// are stopped first, and deleting data for good asks first.
+func (m *model) removeSelected(data string) {
+	var t *Torrent
+	source := "L"
+	if m.view == viewDownloads {
+		if m.selectedID >= len(m.Downloading) {
+			return
+		}
+		t, source = &m.Downloading[m.selectedID], "D"
+	} else {
+		t = m.selectedLibraryItem()
+	}
+	if t == nil {
+		return
+	}
+	if source == "D" && data != keepData && m.findLibraryItem(t.InfoHash) != nil {
+		m.notice = t.Name + "'s files belong to its library entry, remove them from the library"
+		return
+	}
 
-	if err := cmd.Start(); err != nil {
-		log.Printf("couldn't execute command: %v", err)
+	infoHash, name := t.InfoHash, t.Name
+	remove := func() tea.Cmd {
+		if source == "D" {
+			if i := m.downloadIndex(infoHash); i >= 0 {
+				m.selectedID = i
+				m.stopDownload()
+			}
+		}
+		if err := m.removeItem(infoHash, source, data); err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		switch data {
+		case trashData:
+			m.notice = "Moved " + name + " to the trash"
+		case deleteData:
+			m.notice = "Deleted " + name
+		default:
+			m.notice = "Removed " + name + ", its files are kept"
+		}
+		m.UpdateTables()
+		return nil
 	}
 
-	if err := cmd.Wait(); err != nil {
-		log.Printf("couldn't clean up torrent: %v", err)
+	if data == deleteData {
+		m.confirm = &confirmPrompt{
+			text: "Delete " + name + " and its files for good?",
+			yes:  remove,
+		}
+		return
 	}
+	remove()
+}
 
-	m.removeItem(m.Downloading[m.selectedID].Name, "D")
+func (m *model) downloadIndex(infoHash string) int {
+	for i, t := range m.Downloading {
+		if t.InfoHash == infoHash {
+			return i
+		}
+	}
+	return -1
 }
 
-func (m *model) removeItem(name string, source string) {
-	if source == "D" {
-		dir := filepath.Join(homeDir, "Downloads", "Sailor", sanitizeFileName(name))
-		cmd := exec.Command("rm", "-rf", dir)
+// removeItem drops the download or library entry with the given info hash
+// and keeps, trashes or deletes its data. The entry stays when its data
+// couldn't be removed.
+func (m *model) removeItem(infoHash string, source string, data string) error {
+	list := &m.Downloading
+	if source == "L" {
+		list = &m.Library
+	}
 
-		if err := cmd.Start(); err != nil {
-			log.Printf("couldn't execute command: %v", err)
+	var kept []Torrent
+	var removeErr error
+	for _, t := range *list {
+		if t.InfoHash != infoHash {
+			kept = append(kept, t)
+			continue
 		}
 
-		if err := cmd.Wait(); err != nil {
-			log.Printf("couldn't clean up torrent: %v", err)
+		entry := undoEntry{Action: journalRemove, Source: source, Data: data, Torrent: &t}
+		if data != keepData {
+			if err := m.removeData(t, &entry); err != nil {
+				log.Printf("Couldn't remove files of %s: %v", t.Name, err)
+				removeErr = err
+				kept = append(kept, t)
+				continue
+			}
 		}
+		m.recordEvent(eventRemoved, t)
+		m.journal(entry)
+	}
+	*list = kept
+	return removeErr
+}
 
-		var new []Torrent
-		for _, t := range m.Downloading {
-			if t.Name != name {
-				new = append(new, t)
-			}
+// withoutEntry returns list without infoHash's entry. Unlike removeItem it
+// records nothing and leaves the data alone, for entries moving between
+// lists.
+func withoutEntry(list []Torrent, infoHash string) []Torrent {
+	var kept []Torrent
+	for _, t := range list {
+		if t.InfoHash != infoHash {
+			kept = append(kept, t)
 		}
-		m.Downloading = new
-	} else if source == "L" {
-		dir := filepath.Join(homeDir, "Downloads", "Sailor", sanitizeFileName(name))
-		cmd := exec.Command("rm", "-rf", dir)
+	}
+	return kept
+}
 
-		if err := cmd.Start(); err != nil {
-			log.Printf("couldn't execute command: %v", err)
+// removeData trashes or deletes t's data, noting on entry where it was and,
+// for the trash, where it went. Organizing in move mode takes files out of
+// t's directory, so those are removed one by one after it.
+func (m *model) removeData(t Torrent, entry *undoEntry) error {
+	dir := t.Path
+	if dir == "" {
+		dir = t.dir()
+	}
+	var organized []string
+	for _, f := range t.Files {
+		if ensureInside(f, dir) != nil {
+			organized = append(organized, f)
 		}
+	}
+	for _, path := range append([]string{dir}, organized...) {
+		if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+		if err := ensureInside(path, m.dataRoots()...); err != nil {
+			return err
+		}
+	}
 
-		if err := cmd.Wait(); err != nil {
-			log.Printf("couldn't clean up torrent: %v", err)
+	if _, err := os.Lstat(dir); err == nil {
+		trashed, err := removePath(dir, entry.Data)
+		if err != nil {
+			return err
 		}
-		var new []Torrent
-		for _, t := range m.Library {
-			if t.Name != name {
-				new = append(new, t)
-			}
+		entry.From, entry.Trashed = dir, trashed
+	}
+	for _, f := range organized {
+		if _, err := os.Lstat(f); os.IsNotExist(err) {
+			continue
+		}
+		trashed, err := removePath(f, entry.Data)
+		if err != nil {
+			return err
 		}
-		m.Library = new
+		entry.Files = append(entry.Files, removedFile{From: f, Trashed: trashed})
+		removeEmptyParents(filepath.Dir(f), m.settings.Organize.root())
 	}
+	return nil
+}
+
+// removePath trashes or deletes path and returns where it went in the trash.
+func removePath(path, data string) (string, error) {
+	if data == trashData {
+		trashed, err := moveToTrash(path)
+		if err != nil {
+			return "", fmt.Errorf("couldn't move %s to the trash: %w", filepath.Base(path), err)
+		}
+		log.Printf("Moved %s to the trash", path)
+		return trashed, nil
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return "", fmt.Errorf("couldn't delete %s: %w", filepath.Base(path), err)
+	}
+	log.Printf("Deleted %s", path)
+	return "", nil
 }
 
 func SearchTorrents(search string) ([]Torrent, error) {
@@ -158,6 +356,7 @@ func SearchTorrents(search string) ([]Torrent, error) {
 		Leechers string `json:"leechers"`
 		Seeders  string `json:"seeders"`
 		NumFiles string `json:"num_files"`
+		Category string `json:"category"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&tempTorrents); err != nil {
@@ -169,12 +368,16 @@ func SearchTorrents(search string) ([]Torrent, error) {
 		leechers, _ := strconv.Atoi(t.Leechers)
 		seeders, _ := strconv.Atoi(t.Seeders)
 		numFiles, _ := strconv.Atoi(t.NumFiles)
+		bytes, _ := strconv.ParseInt(t.Size, 10, 64)
 		size := formatSize(t.Size)
 
 		torrents = append(torrents, Torrent{
 			InfoHash: t.InfoHash,
 			Name:     t.Name,
 			Size:     size,
+			Bytes:    bytes,
+			Provider: "apibay",
+			Category: t.Category,
 			Leechers: leechers,
 			Seeders:  seeders,
 			NumFiles: numFiles,
@@ -194,66 +397,26 @@ func getFreePort() (int, error) {
 	return addr.Port, nil
 }
 
+// startDownload queues t and starts it.
+func (m *model) startDownload(t Torrent) tea.Cmd {
+	t.DownloadStatus = "pending"
+	t.DirName = filepath.Base(t.dir())
+	t.Query = m.search
+	t.Magnet = CreateMagnetLink(t.InfoHash, t.Name)
+	t.AddedAt = time.Now()
+	m.Downloading = append(m.Downloading, t)
+	m.recordEvent(eventAdded, t)
+	m.UpdateTables()
+	return m.DownloadTorrents()
+}
+
+// DownloadTorrents has Update start the pending downloads and show them.
 func (m *model) DownloadTorrents() tea.Cmd {
 	return func() tea.Msg {
-		for i := range m.Downloading {
-			t := &m.Downloading[i]
-			if t.DownloadStatus == "pending" {
-				safeName := sanitizeFileName(t.Name)
-				downloadDir := filepath.Join(homeDir, "Downloads", "Sailor", safeName)
-
-				if err := os.MkdirAll(downloadDir, 0755); err != nil {
-					log.Printf("Error creating download directory: %v", err)
-					continue
-				}
-
-				var err error
-				t.Port, err = getFreePort()
-				if err != nil {
-					log.Printf("Couldn't find free port: %v", err)
-					continue
-				}
-
-				magnetLink := CreateMagnetLink(t.InfoHash, t.Name)
-
-				cmd := exec.Command("aria2c", "--enable-rpc=true",
-					fmt.Sprintf("--rpc-listen-port=%d", t.Port),
-					"--dir", downloadDir,
-					magnetLink)
-
-				cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-				log.Printf("Running command: %s", strings.Join(cmd.Args, " "))
-				if err := cmd.Start(); err != nil {
-					log.Printf("Failed to start aria2c: %v", err)
-					continue
-				}
-
-				t.PGID, err = syscall.Getpgid(cmd.Process.Pid)
-				t.DownloadStatus = "Downloading"
-				if err != nil {
-					log.Printf("Cannot get process GID: %v", err)
-					continue
-				}
-
-				go func(t *Torrent) {
-					if err := cmd.Wait(); err != nil {
-						t.DownloadStatus = "Failed"
-						log.Printf("aria2c finished with error: %v", err)
-					} else {
-						t.DownloadStatus = "Complete"
-					}
-				}(t)
-			}
-		}
 		return downloadCreateMsg{}
 	}
 }
 
-func sanitizeFileName(name string) string {
-	return strings.ReplaceAll(name, " ", "_")
-}
-
 func CreateMagnetLink(infoHash string, name string) string {
 	magnet := fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s&tr=", infoHash, name)
 	for _, tracker := range trackers {
@@ -262,63 +425,245 @@ func CreateMagnetLink(infoHash string, name string) string {
 	return magnet
 }
 
-func (m *model) GetDownloadInfo() {
-	ticker := time.NewTicker(3 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		for i := range m.Downloading {
-			t := &m.Downloading[i]
-			if t.DownloadStatus == "Downloading" {
-				go func(t *Torrent) {
-					downloadInfo, err := FetchDownloadInfo(t.Port)
-					if err != nil {
-						log.Printf("Error fetching download info for %s (Port: %d): %v", t.Name, t.Port, err)
-						return
-					}
-
-					if len(downloadInfo) > 0 {
-						download := downloadInfo[0]
-						t.Status = download.Status
-						t.CompletedSize = formatSize(download.CompletedSize)
-						t.DownloadSpeed = formatSpeed(download.DownloadSpeed)
-						log.Printf("• %s\nSize: %s\nDownloaded: %s\nSpeed: %s\nStatus: %s\nTime: %s\n",
-							t.Name, t.Size, t.CompletedSize, t.DownloadSpeed, t.Status, t.Time)
-
-						if t.Size == t.CompletedSize {
-							t.DownloadStatus = "Complete"
-						}
-					} else {
-						log.Printf("No download info received for %s (Port: %d)", t.Name, t.Port)
-					}
-				}(t)
-			} else if t.DownloadStatus == "Complete" {
-				t.DownloadStatus = "Stored"
-				//m.removeItem(t.Name, "D") // #FIX I changed this function and now it deletes the files instead of just the Torrent from the downloads
-				// While you're at it restructure fetched info so you can calculate ETA like a normal huma being
-				m.Library = append(m.Library, *t)
+type processedMsg struct {
+	torrent Torrent
+}
+
+// postProcess takes a finished download through extraction. Extracting can
+// take a while and Update may grow m.Downloading in the meantime, moving
+// the entry, so the work is done on a copy and Update files the result.
+func (m *model) postProcess(done Torrent) {
+	done.Files = listFiles(done.dir())
+	if m.settings.Extract.Enabled {
+		m.extractArchives(&done)
+	}
+	m.send(processedMsg{torrent: done})
+}
+
+// finishProcessing moves a processed download into the library, runs the
+// completion hooks on it and stops its aria2c, which would otherwise keep
+// seeding since RPC keeps it running. The library entry keeps the process
+// group until aria2c is gone.
+func (m *model) finishProcessing(done Torrent) tea.Cmd {
+	t := m.findDownload(done.InfoHash)
+	if t == nil || (t.DownloadStatus != "Processing" && t.DownloadStatus != "Extracting") {
+		log.Printf("%s was removed while it was being processed", done.Name)
+		return nil
+	}
+
+	t.Files = done.Files
+	t.ExtractedFiles = done.ExtractedFiles
+	t.LastError = done.LastError
+	t.DownloadStatus = "Stored"
+	t.Repair = false
+	t.CompletedAt = time.Now()
+	t.Path = t.dir()
+	// While you're at it restructure fetched info so you can calculate ETA like a normal huma being
+	stored := *t
+	m.Library = append(m.Library, stored)
+	m.Downloading = withoutEntry(m.Downloading, stored.InfoHash)
+	m.recordEvent(eventCompleted, stored)
+	go m.runHooks(stored)
+	if stored.PGID == 0 {
+		return nil
+	}
+	return func() tea.Msg {
+		if err := callAria2(stored.Port, "aria2.shutdown", nil, nil); err != nil {
+			log.Printf("Couldn't shut down aria2c for %s, sending SIGTERM: %v", stored.Name, err)
+			stored.killAria2c(syscall.SIGTERM)
+		}
+		return nil
+	}
+}
+
+// pollMsg has Update poll the running downloads.
+type pollMsg struct{}
+
+// downloadInfoMsg is what a download's aria2c answered when polled.
+type downloadInfoMsg struct {
+	infoHash string
+	port     int
+	info     *aria2Status
+	stat     *aria2GlobalStat
+	failed   *aria2Status
+	err      error
+}
+
+func (m *model) pollTick() tea.Cmd {
+	return tea.Tick(m.settings.PollInterval, func(time.Time) tea.Msg {
+		return pollMsg{}
+	})
+}
+
+// poll runs the periodic checks, hands finished downloads to post
+// processing and asks every running aria2c how its download is doing. The
+// answers come back to Update as downloadInfoMsg.
+func (m *model) poll() tea.Cmd {
+	m.checkDiskSpace()
+	m.recordTransfers()
+	m.checkDataCap()
+
+	cmds := []tea.Cmd{m.pollTick()}
+	for i := range m.Downloading {
+		t := &m.Downloading[i]
+		switch t.DownloadStatus {
+		case "Downloading":
+			cmds = append(cmds, fetchDownloadInfo(t.InfoHash, t.Port))
+		case "Processing", "Extracting":
+			// The aria2c seeds until post-processing is done and
+			// finishProcessing stops it.
+			if t.Port != 0 {
+				cmds = append(cmds, fetchSeedingStat(t.InfoHash, t.Port))
+			}
+		case "Complete":
+			t.DownloadStatus = "Processing"
+			if m.settings.Extract.Enabled {
+				t.DownloadStatus = "Extracting"
 			}
+			go m.postProcess(*t)
 		}
 	}
+	return tea.Batch(cmds...)
 }
 
-func FetchDownloadInfo(port int) ([]Torrent, error) {
+func fetchDownloadInfo(infoHash string, port int) tea.Cmd {
+	return func() tea.Msg {
+		msg := downloadInfoMsg{infoHash: infoHash, port: port}
+		downloads, err := FetchDownloadInfo(port)
+		if err != nil {
+			msg.err = err
+			return msg
+		}
+		if len(downloads) > 0 {
+			msg.info = &downloads[0]
+			if stat, err := FetchGlobalStat(port); err == nil {
+				msg.stat = stat
+			}
+		} else if failed, err := FetchFailedDownload(port); err == nil {
+			msg.failed = failed
+		}
+		return msg
+	}
+}
+
+// fetchSeedingStat polls the global stats of a finished download's aria2c,
+// which keeps uploading while the download is post-processed.
+func fetchSeedingStat(infoHash string, port int) tea.Cmd {
+	return func() tea.Msg {
+		stat, err := FetchGlobalStat(port)
+		return downloadInfoMsg{infoHash: infoHash, port: port, stat: stat, err: err}
+	}
+}
+
+// downloadInfo puts a poll's answer on the download it was for, as long as
+// that is still the aria2c that answered.
+func (m *model) downloadInfo(msg downloadInfoMsg) {
+	t := m.findDownload(msg.infoHash)
+	if t == nil || t.Port != msg.port {
+		return
+	}
+	// A paused aria2c has nothing active and its last speeds must not keep
+	// counting towards the stats and the data cap.
+	t.DownloadRate, t.UploadRate = 0, 0
+	if msg.stat != nil && (msg.info == nil || msg.info.Status == "active") {
+		t.DownloadRate, _ = strconv.ParseInt(msg.stat.DownloadSpeed, 10, 64)
+		t.UploadRate, _ = strconv.ParseInt(msg.stat.UploadSpeed, 10, 64)
+	}
+	if t.DownloadStatus != "Downloading" {
+		return
+	}
+	if msg.err != nil {
+		log.Printf("Error fetching download info for %s (Port: %d): %v", t.Name, t.Port, msg.err)
+		return
+	}
+
+	switch {
+	case msg.info != nil:
+		download := msg.info
+		t.GID = download.GID
+		t.Status = download.Status
+		t.CompletedSize = formatSize(download.CompletedLength)
+		t.DownloadSpeed = formatSpeed(download.DownloadSpeed)
+		log.Printf("• %s\nSize: %s\nDownloaded: %s\nSpeed: %s\nStatus: %s\nTime: %s\n",
+			t.Name, t.Size, t.CompletedSize, t.DownloadSpeed, t.Status, t.Time)
+
+		if t.Size == t.CompletedSize {
+			t.DownloadStatus = "Complete"
+		}
+	case msg.failed != nil:
+		m.failDownload(t, msg.failed)
+	default:
+		log.Printf("No download info received for %s (Port: %d)", t.Name, t.Port)
+	}
+}
+
+// aria2GlobalStat is the part of aria2's getGlobalStat answer sailor looks
+// at. It covers everything the aria2c moves, including the metadata of a
+// magnet link.
+type aria2GlobalStat struct {
+	DownloadSpeed string `json:"downloadSpeed"`
+	UploadSpeed   string `json:"uploadSpeed"`
+}
+
+func FetchGlobalStat(port int) (*aria2GlobalStat, error) {
+	var stat aria2GlobalStat
+	if err := callAria2(port, "aria2.getGlobalStat", nil, &stat); err != nil {
+		return nil, err
+	}
+	return &stat, nil
+}
+
+// FetchFailedDownload returns the first download aria2 stopped with an
+// error, or nil if there is none.
+func FetchFailedDownload(port int) (*aria2Status, error) {
+	var stopped []aria2Status
+	keys := []string{"gid", "status", "errorCode", "errorMessage"}
+	if err := callAria2(port, "aria2.tellStopped", []any{0, 10, keys}, &stopped); err != nil {
+		return nil, err
+	}
+
+	for i := range stopped {
+		if stopped[i].Status == "error" {
+			return &stopped[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func FetchDownloadInfo(port int) ([]aria2Status, error) {
 	log.Printf("Fetching download info on port: %d", port)
 
+	var downloads []aria2Status
+	if err := callAria2(port, "aria2.tellActive", nil, &downloads); err != nil {
+		log.Printf("Error sending request: %v", err)
+		return nil, err
+	}
+
+	return downloads, nil
+}
+
+// callAria2 calls method on the aria2c instance listening on port, prepending
+// the secret token to params, and decodes the result into result if non-nil.
+func callAria2(port int, method string, params []any, result any) error {
 	req := Request{
 		JSONRPC: "2.0",
 		ID:      "1",
-		Method:  "aria2.tellActive",
-		Params:  []string{"token:" + aria2SecretToken},
+		Method:  method,
+		Params:  append([]any{"token:" + aria2SecretToken}, params...),
 	}
 
 	resp, err := sendRequest(req, port)
 	if err != nil {
-		log.Printf("Error sending request: %v", err)
-		return nil, err
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil {
+		return nil
 	}
 
-	return resp.Result, nil
+	return json.Unmarshal(resp.Result, result)
 }
 
 func sendRequest(req Request, port int) (*Response, error) {
@@ -327,18 +672,17 @@ func sendRequest(req Request, port int) (*Response, error) {
 		return nil, err
 	}
 
-	resp, err := http.Post(fmt.Sprintf(aria2URL, port), "application/json", bytes.NewBuffer(jsonData))
+	resp, err := rpcClient.Post(fmt.Sprintf(aria2URL, port), "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to send request: %s", resp.Status)
-	}
-
 	var response Response
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to send request: %s", resp.Status)
+		}
 		return nil, err
 	}
 
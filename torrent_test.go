@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestRemovingDownloadKeepsLibraryData(t *testing.T) {
+	withPaths(t)
+	item := Torrent{InfoHash: "ABCDEF0123456789", Name: "A Movie 2019", DownloadStatus: "Stored"}
+	if err := os.MkdirAll(item.dir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(item.dir(), "A.Movie.2019.mkv")
+	if err := os.WriteFile(file, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(DefaultSettings())
+	m.Library = []Torrent{item}
+	leftover := item
+	leftover.DownloadStatus = "Failed"
+	leftover.PGID, leftover.Port = 1, 6800
+	m.Downloading = []Torrent{leftover}
+	m.view = viewDownloads
+
+	for _, data := range []string{trashData, deleteData} {
+		m.removeSelected(data)
+		if m.confirm != nil {
+			m.confirm.yes()
+			m.confirm = nil
+		}
+		if _, err := os.Stat(file); err != nil {
+			t.Fatalf("removing the download with %s took the library's files: %v", data, err)
+		}
+		if m.notice == "" {
+			t.Errorf("removing the download with %s said nothing", data)
+		}
+	}
+	if m.Downloading[0].DownloadStatus != "Failed" {
+		t.Errorf("refused removal still cancelled the download")
+	}
+}
+
+// fakeAria2 answers aria2 RPC calls on a local port and records their
+// methods.
+func fakeAria2(t *testing.T) (int, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		methods = append(methods, req.Method)
+		mu.Unlock()
+		w.Write([]byte(`{"jsonrpc": "2.0", "id": "1", "result": "OK"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), methods...)
+	}
+}
+
+func TestFinishedDownloadStopsAria2c(t *testing.T) {
+	port, methods := fakeAria2(t)
+	m := New(DefaultSettings())
+	m.Downloading = []Torrent{{InfoHash: "A", Name: "Show", DownloadStatus: "Processing", PGID: 4242, Port: port}}
+
+	cmd := m.finishProcessing(Torrent{InfoHash: "A", Name: "Show"})
+	if cmd == nil {
+		t.Fatal("finishing a download left its aria2c running")
+	}
+	cmd()
+	if got := methods(); len(got) != 1 || got[0] != "aria2.shutdown" {
+		t.Errorf("aria2c got %v, want aria2.shutdown", got)
+	}
+
+	item := m.findLibraryItem("A")
+	if item == nil || item.PGID != 4242 || item.Port != port {
+		t.Fatalf("library entry lost track of aria2c before it exited: %+v", item)
+	}
+	m.aria2cExited(aria2cExitedMsg{infoHash: "A", pgid: 4242})
+	if item.PGID != 0 || item.Port != 0 {
+		t.Errorf("library entry still tracks process group %d after it exited", item.PGID)
+	}
+	if item.DownloadStatus != "Stored" || m.findDownload("A") != nil {
+		t.Errorf("aria2c exiting changed the stored download: %+v", item)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// moveToTrash moves path into the freedesktop.org trash, writing the
+// .trashinfo file that lets file managers restore it. Paths on another
+// device go to that device's .Trash-$uid so nothing is copied across disks.
+// It returns where path ended up in the trash.
+func moveToTrash(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	trash, infoPath := homeTrash(), abs
+	if !sameDevice(abs, trash) {
+		top := mountPoint(abs)
+		trash = filepath.Join(top, fmt.Sprintf(".Trash-%d", os.Getuid()))
+		infoPath, _ = filepath.Rel(top, abs)
+	}
+	for _, dir := range []string{"files", "info"} {
+		if err := os.MkdirAll(filepath.Join(trash, dir), 0700); err != nil {
+			return "", err
+		}
+	}
+
+	// The info file is created first and exclusively, which is how the
+	// spec reserves a name in the trash.
+	base := filepath.Base(abs)
+	for n := 1; ; n++ {
+		name := base
+		if n > 1 {
+			name = fmt.Sprintf("%s.%d", base, n)
+		}
+		info := filepath.Join(trash, "info", name+".trashinfo")
+		file, err := os.OpenFile(info, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		_, err = fmt.Fprintf(file, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+			trashInfoPath(infoPath), time.Now().Format("2006-01-02T15:04:05"))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		trashed := filepath.Join(trash, "files", name)
+		if err == nil {
+			err = os.Rename(abs, trashed)
+		}
+		if err != nil {
+			os.Remove(info)
+			return "", err
+		}
+		return trashed, nil
+	}
+}
+
+// restoreFromTrash moves a path moveToTrash returned back to to and drops
+// its .trashinfo file.
+func restoreFromTrash(trashed, to string) error {
+	if _, err := os.Lstat(trashed); os.IsNotExist(err) {
+		return fmt.Errorf("%s is no longer in the trash", filepath.Base(trashed))
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return fmt.Errorf("%s already exists", to)
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(trashed, to); err != nil {
+		return err
+	}
+	info := filepath.Join(filepath.Dir(filepath.Dir(trashed)), "info", filepath.Base(trashed)+".trashinfo")
+	os.Remove(info)
+	return nil
+}
+
+func homeTrash() string {
+	return filepath.Join(xdgDir("XDG_DATA_HOME", ".local", "share"), "Trash")
+}
+
+// trashInfoPath escapes path the way .trashinfo files expect, keeping the
+// slashes.
+func trashInfoPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+func device(path string) (uint64, bool) {
+	var st syscall.Stat_t
+	for {
+		if err := syscall.Stat(path, &st); err == nil {
+			return uint64(st.Dev), true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return 0, false
+		}
+		path = parent
+	}
+}
+
+// sameDevice reports whether a and b are on the same filesystem. b may not
+// exist yet, in which case its nearest existing parent counts.
+func sameDevice(a, b string) bool {
+	da, okA := device(a)
+	db, okB := device(b)
+	return okA && okB && da == db
+}
+
+func mountPoint(path string) string {
+	dev, _ := device(path)
+	for {
+		parent := filepath.Dir(path)
+		if d, ok := device(parent); parent == path || !ok || d != dev {
+			return path
+		}
+		path = parent
+	}
+}
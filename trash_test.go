@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrashInfoPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/home/me/Downloads/Show", "/home/me/Downloads/Show"},
+		{"/home/me/A Movie (2019)", "/home/me/A%20Movie%20%282019%29"},
+		{"/data/100%/x#y?z", "/data/100%25/x%23y%3Fz"},
+		{"Downloads/Åsa", "Downloads/%C3%85sa"},
+	}
+	for _, tt := range tests {
+		if got := trashInfoPath(tt.path); got != tt.want {
+			t.Errorf("trashInfoPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// readTrashInfo returns the Path and DeletionDate lines of a .trashinfo file.
+func readTrashInfo(t *testing.T, path string) (string, string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0] != "[Trash Info]" {
+		t.Fatalf("%s = %q, want a [Trash Info] header, Path and DeletionDate", path, data)
+	}
+	return strings.TrimPrefix(lines[1], "Path="), strings.TrimPrefix(lines[2], "DeletionDate=")
+}
+
+func TestMoveToTrashAndRestore(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", home)
+	trash := filepath.Join(home, "Trash")
+	dir := t.TempDir()
+
+	var trashed []string
+	for i, content := range []string{"first", "second"} {
+		path := filepath.Join(dir, "A Movie (2019)")
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "movie.mkv"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		before := time.Now().Truncate(time.Second)
+		got, err := moveToTrash(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is still there after trashing it", path)
+		}
+
+		name := "A Movie (2019)"
+		if i > 0 {
+			name += ".2"
+		}
+		if want := filepath.Join(trash, "files", name); got != want {
+			t.Fatalf("trashed to %s, want %s", got, want)
+		}
+		infoPath, deleted := readTrashInfo(t, filepath.Join(trash, "info", name+".trashinfo"))
+		if want := trashInfoPath(path); infoPath != want {
+			t.Errorf("trashinfo Path = %q, want %q", infoPath, want)
+		}
+		at, err := time.ParseInLocation("2006-01-02T15:04:05", deleted, time.Local)
+		if err != nil || at.Before(before) || at.After(time.Now()) {
+			t.Errorf("trashinfo DeletionDate = %q, want the time it was trashed", deleted)
+		}
+		trashed = append(trashed, got)
+	}
+
+	// The first copy goes back where it was; the second can't while the
+	// first is there.
+	original := filepath.Join(dir, "A Movie (2019)")
+	if err := restoreFromTrash(trashed[0], original); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(original, "movie.mkv")); string(data) != "first" {
+		t.Errorf("restored file holds %q, want first", data)
+	}
+	if _, err := os.Stat(filepath.Join(trash, "info", "A Movie (2019).trashinfo")); !os.IsNotExist(err) {
+		t.Error("restoring left the .trashinfo file behind")
+	}
+	if err := restoreFromTrash(trashed[1], original); err == nil {
+		t.Error("restoring over an existing directory succeeded")
+	}
+	if err := restoreFromTrash(trashed[0], filepath.Join(dir, "again")); err == nil {
+		t.Error("restoring something no longer in the trash succeeded")
+	}
+
+	// Restoring recreates missing parents.
+	elsewhere := filepath.Join(dir, "gone", "A Movie (2019)")
+	if err := restoreFromTrash(trashed[1], elsewhere); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(elsewhere, "movie.mkv")); string(data) != "second" {
+		t.Errorf("restored file holds %q, want second", data)
+	}
+}
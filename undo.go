@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	journalRemove   = "remove"
+	journalOrganize = "organize"
+	journalRename   = "rename"
+
+	// undoJournalSize is how many actions are kept for undo.
+	undoJournalSize = 100
+)
+
+var undoLogPath = filepath.Join(downloadRoot, ".undo.jsonl")
+
+// undoEntry is one action in the undo journal. Removals, which cover
+// cancelled downloads, keep the whole entry and where its data went;
+// organizing keeps the batch to revert from the organize log and renames
+// keep the old name.
+type undoEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Name   string    `json:"name"`
+
+	Source  string        `json:"source,omitempty"`
+	Data    string        `json:"data,omitempty"`
+	Torrent *Torrent      `json:"torrent,omitempty"`
+	From    string        `json:"from,omitempty"`
+	Trashed string        `json:"trashed,omitempty"`
+	Files   []removedFile `json:"files,omitempty"`
+
+	Batch string `json:"batch,omitempty"`
+
+	InfoHash string `json:"info_hash,omitempty"`
+	OldName  string `json:"old_name,omitempty"`
+}
+
+// removedFile is an organized file removed along with its entry, which
+// organizing had moved out of the entry's directory.
+type removedFile struct {
+	From    string `json:"from"`
+	Trashed string `json:"trashed,omitempty"`
+}
+
+// journal adds an action to the undo journal, dropping the oldest ones
+// past undoJournalSize.
+func (m *model) journal(entry undoEntry) {
+	entry.Time = time.Now()
+	if entry.Name == "" && entry.Torrent != nil {
+		entry.Name = entry.Torrent.Name
+	}
+
+	entries, err := readUndoJournal()
+	if err != nil {
+		log.Printf("Couldn't read undo journal: %v", err)
+	}
+	entries = append(entries, entry)
+	if err := writeUndoJournal(entries); err != nil {
+		log.Printf("Couldn't write undo journal: %v", err)
+	}
+}
+
+// undo reverts the most recent action in the journal and describes what it
+// did. Actions that can never be undone, like a removal whose trash has
+// since been emptied, are dropped from the journal along with the error.
+func (m *model) undo() (string, error) {
+	entries, err := readUndoJournal()
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", errors.New("nothing to undo")
+	}
+
+	entry := entries[len(entries)-1]
+	var notice string
+	var keep bool
+	switch entry.Action {
+	case journalRemove:
+		notice, keep, err = m.undoRemove(entry)
+	case journalOrganize:
+		notice, keep, err = m.undoOrganizeEntry(entry)
+	case journalRename:
+		notice, err = m.undoRename(entry)
+	default:
+		err = fmt.Errorf("can't undo %q", entry.Action)
+	}
+	if err != nil && keep {
+		return "", err
+	}
+
+	if err := writeUndoJournal(entries[:len(entries)-1]); err != nil {
+		log.Printf("Couldn't rewrite undo journal: %v", err)
+	}
+	return notice, err
+}
+
+// undoRemove puts a removed entry back, along with its files when they went
+// to the trash. A restored download starts again unless it had finished,
+// in which case it goes back to the library.
+func (m *model) undoRemove(entry undoEntry) (string, bool, error) {
+	if entry.Torrent == nil {
+		return "", false, errors.New("the undo journal entry has no torrent")
+	}
+	t := *entry.Torrent
+	stored := entry.Source == "L" || t.DownloadStatus == "Stored"
+	if m.findDownload(t.InfoHash) != nil || (stored && m.findLibraryItem(t.InfoHash) != nil) {
+		return "", false, fmt.Errorf("%s is already back", t.Name)
+	}
+
+	notice := "Restored " + t.Name
+	trashed := entry.Trashed != ""
+	if entry.Trashed != "" {
+		if _, err := os.Lstat(entry.Trashed); os.IsNotExist(err) {
+			return "", false, fmt.Errorf("can't restore %s: its files are no longer in the trash", t.Name)
+		}
+		if err := restoreFromTrash(entry.Trashed, entry.From); err != nil {
+			return "", true, fmt.Errorf("couldn't restore %s from the trash: %w", t.Name, err)
+		}
+	}
+	for _, f := range entry.Files {
+		if f.Trashed == "" {
+			continue
+		}
+		trashed = true
+		if err := restoreFromTrash(f.Trashed, f.From); err != nil {
+			log.Printf("Couldn't restore %s from the trash: %v", f.From, err)
+			t.Missing = stored
+		}
+	}
+	if trashed {
+		notice += " and its files from the trash"
+	} else if entry.Data == deleteData && (entry.From != "" || len(entry.Files) > 0) {
+		notice += ", its files were deleted for good"
+		t.Missing = stored
+	}
+
+	t.PGID, t.Port, t.Detached = 0, 0, false
+	if stored {
+		m.Library = append(m.Library, t)
+	} else {
+		t.DownloadStatus = "pending"
+		m.Downloading = append(m.Downloading, t)
+	}
+	m.recordEvent(eventAdded, t)
+	return notice, false, nil
+}
+
+// undoOrganizeEntry reverts an organize batch, as long as it is still the
+// latest one in the organize log.
+func (m *model) undoOrganizeEntry(entry undoEntry) (string, bool, error) {
+	steps, err := readOrganizeLog()
+	if err != nil {
+		return "", true, err
+	}
+	if len(steps) == 0 || steps[len(steps)-1].Batch != entry.Batch {
+		return "", false, fmt.Errorf("organizing %s was already undone", entry.Name)
+	}
+	if err := m.undoOrganize(); err != nil {
+		return "", true, err
+	}
+	return "Undid organizing " + entry.Name, false, nil
+}
+
+// undoLastOrganize reverts the latest organize batch whether or not it is
+// the latest action, and drops it from the undo journal.
+func (m *model) undoLastOrganize() (string, error) {
+	steps, err := readOrganizeLog()
+	if err != nil {
+		return "", err
+	}
+	if len(steps) == 0 {
+		return "", errors.New("nothing to undo")
+	}
+	batch := steps[len(steps)-1].Batch
+	if err := m.undoOrganize(); err != nil {
+		return "", err
+	}
+
+	entries, err := readUndoJournal()
+	if err != nil {
+		log.Printf("Couldn't read undo journal: %v", err)
+		return "Undid last organize", nil
+	}
+	var kept []undoEntry
+	for _, entry := range entries {
+		if entry.Action != journalOrganize || entry.Batch != batch {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) != len(entries) {
+		if err := writeUndoJournal(kept); err != nil {
+			log.Printf("Couldn't rewrite undo journal: %v", err)
+		}
+	}
+	return "Undid last organize", nil
+}
+
+// undoRename gives a library entry its old name back.
+func (m *model) undoRename(entry undoEntry) (string, error) {
+	item := m.findLibraryItem(entry.InfoHash)
+	if item == nil {
+		return "", fmt.Errorf("%s is no longer in the library", entry.Name)
+	}
+	item.Name = entry.OldName
+	return fmt.Sprintf("Renamed %s back to %s", entry.Name, entry.OldName), nil
+}
+
+func readUndoJournal() ([]undoEntry, error) {
+	file, err := os.Open(undoLogPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []undoEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry undoEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("Skipping bad undo journal line: %v", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func writeUndoJournal(entries []undoEntry) error {
+	if len(entries) > undoJournalSize {
+		entries = entries[len(entries)-undoJournalSize:]
+	}
+	return replaceFile(undoLogPath, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// runUndo is `sailor undo`: it reverts the latest action without starting
+// the interface.
+func runUndo(m *model) int {
+	store, err := openStore(false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening download state: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+	m.store = store
+	if err := m.loadDownloadState(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading download state: %v\n", err)
+		return 1
+	}
+
+	notice, err := m.undo()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := m.saveDownloadState(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving download state: %v\n", err)
+		return 1
+	}
+	fmt.Println(notice)
+	return 0
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestUndoJournalIsTrimmedOnWrite(t *testing.T) {
+	withPaths(t)
+	m := New(DefaultSettings())
+	m.Library = []Torrent{{InfoHash: "A", Name: "0"}}
+	for i := 1; i <= undoJournalSize+5; i++ {
+		m.renameLibraryItem("A", strconv.Itoa(i))
+	}
+
+	entries, err := readUndoJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != undoJournalSize {
+		t.Fatalf("journal has %d entries, want %d", len(entries), undoJournalSize)
+	}
+	if got := entries[0].OldName; got != "5" {
+		t.Errorf("oldest journal entry renames from %q, want 5", got)
+	}
+}
+
+func TestUndoRemovedStoredDownload(t *testing.T) {
+	withPaths(t)
+	m := New(DefaultSettings())
+	m.Downloading = []Torrent{{InfoHash: "A", Name: "Finished", DownloadStatus: "Stored"}}
+	if err := m.removeItem("A", "D", keepData); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.undo(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Downloading) != 0 {
+		t.Errorf("undo requeued the finished download: %+v", m.Downloading)
+	}
+	if item := m.findLibraryItem("A"); item == nil || item.DownloadStatus != "Stored" {
+		t.Errorf("library entry = %+v, want the stored download", item)
+	}
+	if _, err := m.undo(); err == nil {
+		t.Error("undo with an empty journal succeeded")
+	}
+}
+
+func TestUndoRemovedDownloadIsRequeued(t *testing.T) {
+	withPaths(t)
+	m := New(DefaultSettings())
+	m.Downloading = []Torrent{{InfoHash: "A", Name: "Half done", DownloadStatus: "Failed", Port: 6801, PGID: 4242}}
+	if err := m.removeItem("A", "D", keepData); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.undo(); err != nil {
+		t.Fatal(err)
+	}
+	d := m.findDownload("A")
+	if d == nil || d.DownloadStatus != "pending" || d.Port != 0 || d.PGID != 0 {
+		t.Errorf("download = %+v, want it pending again", d)
+	}
+	if m.findLibraryItem("A") != nil {
+		t.Error("an unfinished download was put in the library")
+	}
+}
+
+func TestUndoRename(t *testing.T) {
+	withPaths(t)
+	m := New(DefaultSettings())
+	m.Library = []Torrent{{InfoHash: "A", Name: "Show S01E01 1080p"}}
+	m.renameLibraryItem("A", "Show, the pilot")
+
+	notice, err := m.undo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Library[0].Name; got != "Show S01E01 1080p" {
+		t.Errorf("name after undo = %q (%s)", got, notice)
+	}
+}
+
+func TestUndoLastOrganizeDropsJournalEntry(t *testing.T) {
+	downloads, _ := withPaths(t)
+	m := New(DefaultSettings())
+	from := filepath.Join(downloads, "Show_S01E01", "Show.S01E01.mkv")
+	to := filepath.Join(downloads, "TV", "Show", "Season 01", "Show - S01E01.mkv")
+	if err := os.MkdirAll(filepath.Dir(from), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(from, []byte("episode"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.Library = []Torrent{{InfoHash: "A", Name: "Show S01E01", Files: []string{from}}}
+
+	plan := []organizeStep{{Batch: "1", InfoHash: "A", From: from, To: to, Mode: organizeMove}}
+	if err := m.applyOrganize(plan); err != nil {
+		t.Fatal(err)
+	}
+	m.renameLibraryItem("A", "Pilot")
+
+	if _, err := m.undoLastOrganize(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(from); err != nil {
+		t.Errorf("file wasn't moved back: %v", err)
+	}
+	entries, err := readUndoJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != journalRename {
+		t.Errorf("journal = %+v, want only the rename", entries)
+	}
+}
+
+func TestFailedJournalWriteKeepsOldJournal(t *testing.T) {
+	withPaths(t)
+	m := New(DefaultSettings())
+	m.Library = []Torrent{{InfoHash: "A", Name: "Old"}}
+	m.renameLibraryItem("A", "New")
+
+	err := replaceFile(undoLogPath, func(w io.Writer) error {
+		w.Write([]byte(`{"action": "rena`))
+		return errors.New("disk full")
+	})
+	if err == nil {
+		t.Fatal("replaceFile hid the write error")
+	}
+
+	entries, err := readUndoJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].OldName != "Old" {
+		t.Errorf("journal after a failed rewrite = %+v, want the rename", entries)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(undoLogPath), ".*.tmp"))
+	if len(leftovers) != 0 {
+		t.Errorf("failed rewrite left %q behind", leftovers)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const metadataFetchTimeout = 2 * time.Minute
+
+type VerifyResult struct {
+	At    time.Time `json:"at"`
+	Good  int       `json:"good"`
+	Total int       `json:"total"`
+}
+
+func (v VerifyResult) Percent() float64 {
+	if v.Total == 0 {
+		return 0
+	}
+	return float64(v.Good) * 100 / float64(v.Total)
+}
+
+type verifyDoneMsg struct {
+	infoHash string
+	result   VerifyResult
+	// organized is set when files were read from where organizing moved
+	// them, which aria2 can't repair in place.
+	organized bool
+	err       error
+}
+
+// decodeBencode decodes one bencoded value from data, returning it and the
+// rest of the input. Dictionaries become map[string]any, lists []any,
+// integers int64 and strings string.
+func decodeBencode(data []byte) (any, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	switch {
+	case data[0] == 'i':
+		end := bytes.IndexByte(data, 'e')
+		if end < 0 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		n, err := strconv.ParseInt(string(data[1:end]), 10, 64)
+		return n, data[end+1:], err
+	case data[0] == 'l':
+		var list []any
+		data = data[1:]
+		for len(data) > 0 && data[0] != 'e' {
+			var v any
+			var err error
+			if v, data, err = decodeBencode(data); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, v)
+		}
+		if len(data) == 0 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		return list, data[1:], nil
+	case data[0] == 'd':
+		dict := make(map[string]any)
+		data = data[1:]
+		for len(data) > 0 && data[0] != 'e' {
+			k, rest, err := decodeBencode(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, nil, errors.New("bencode: dictionary key is not a string")
+			}
+			var v any
+			if v, data, err = decodeBencode(rest); err != nil {
+				return nil, nil, err
+			}
+			dict[key] = v
+		}
+		if len(data) == 0 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		return dict, data[1:], nil
+	case data[0] >= '0' && data[0] <= '9':
+		colon := bytes.IndexByte(data, ':')
+		if colon < 0 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		n, err := strconv.Atoi(string(data[:colon]))
+		if err != nil || n < 0 || colon+1+n > len(data) {
+			return nil, nil, errors.New("bencode: bad string length")
+		}
+		return string(data[colon+1 : colon+1+n]), data[colon+1+n:], nil
+	}
+	return nil, nil, fmt.Errorf("bencode: unexpected %q", data[0])
+}
+
+type metaFile struct {
+	path   string
+	length int64
+}
+
+type torrentMeta struct {
+	pieceLength int64
+	pieces      []string
+	files       []metaFile
+}
+
+// parseTorrentFile reads the piece hashes and file layout out of a .torrent.
+// File paths are relative to the download directory, the way aria2 lays
+// them out.
+func parseTorrentFile(path string) (*torrentMeta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v, _, err := decodeBencode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	root, _ := v.(map[string]any)
+	info, ok := root["info"].(map[string]any)
+	if !ok {
+		return nil, errors.New("torrent has no info dictionary")
+	}
+	name, _ := info["name"].(string)
+	pieceLength, _ := info["piece length"].(int64)
+	pieces, _ := info["pieces"].(string)
+	if pieceLength <= 0 || len(pieces)%sha1.Size != 0 {
+		return nil, errors.New("torrent has bad piece information")
+	}
+
+	meta := &torrentMeta{pieceLength: pieceLength}
+	for i := 0; i < len(pieces); i += sha1.Size {
+		meta.pieces = append(meta.pieces, pieces[i:i+sha1.Size])
+	}
+
+	if length, ok := info["length"].(int64); ok {
+		meta.files = []metaFile{{path: name, length: length}}
+		return meta, nil
+	}
+	files, _ := info["files"].([]any)
+	for _, f := range files {
+		file, _ := f.(map[string]any)
+		length, _ := file["length"].(int64)
+		var parts []string
+		pathList, _ := file["path"].([]any)
+		for _, p := range pathList {
+			part, _ := p.(string)
+			parts = append(parts, part)
+		}
+		meta.files = append(meta.files, metaFile{
+			path:   filepath.Join(append([]string{name}, parts...)...),
+			length: length,
+		})
+	}
+	return meta, nil
+}
+
+// multiFileReader reads the torrent's files back to back as one stream.
+// Missing or short files read as zeroes so their pieces simply fail to
+// match.
+type multiFileReader struct {
+	dir   string
+	files []metaFile
+	moved map[string]string
+	index int
+	read  int64
+	file  *os.File
+}
+
+func (r *multiFileReader) Read(p []byte) (int, error) {
+	for r.index < len(r.files) {
+		current := r.files[r.index]
+		if r.read >= current.length {
+			if r.file != nil {
+				r.file.Close()
+				r.file = nil
+			}
+			r.index++
+			r.read = 0
+			continue
+		}
+
+		if r.file == nil {
+			path := filepath.Join(r.dir, current.path)
+			if to, ok := r.moved[path]; ok {
+				path = to
+			}
+			r.file, _ = os.Open(path)
+		}
+		want := min(int64(len(p)), current.length-r.read)
+		n := 0
+		if r.file != nil {
+			n, _ = r.file.ReadAt(p[:want], r.read)
+		}
+		clear(p[n:want])
+		r.read += want
+		return int(want), nil
+	}
+	return 0, io.EOF
+}
+
+func (r *multiFileReader) Close() {
+	if r.file != nil {
+		r.file.Close()
+	}
+}
+
+// hashPieces re-hashes the data under dir against meta and returns which
+// pieces are intact. Files in moved are read from where they were moved to.
+func hashPieces(dir string, meta *torrentMeta, moved map[string]string) VerifyResult {
+	reader := &multiFileReader{dir: dir, files: meta.files, moved: moved}
+	defer reader.Close()
+
+	result := VerifyResult{At: time.Now(), Total: len(meta.pieces)}
+	buf := make([]byte, meta.pieceLength)
+	for _, want := range meta.pieces {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			break
+		}
+		if sum := sha1.Sum(buf[:n]); string(sum[:]) == want {
+			result.Good++
+		}
+	}
+	return result
+}
+
+func metadataPath(dir, infoHash string) string {
+	return filepath.Join(dir, strings.ToLower(infoHash)+".torrent")
+}
+
+// fetchMetadata asks the swarm for just the torrent metadata, for items
+// downloaded before sailor started keeping it.
+func fetchMetadata(t Torrent, dir string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataFetchTimeout)
+	defer cancel()
+
+	magnet := t.Magnet
+	if magnet == "" {
+		magnet = CreateMagnetLink(t.InfoHash, t.Name)
+	}
+	cmd := exec.CommandContext(ctx, "aria2c",
+		"--bt-metadata-only=true",
+		"--bt-save-metadata=true",
+		"--dir", dir,
+		magnet)
+	output := &tailBuffer{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("fetching metadata: %v: %s", err, output.LastLine())
+	}
+	return nil
+}
+
+// movedFiles maps each of a download's files that organizing moved, as
+// opposed to hardlinked, to where it is now.
+func movedFiles(infoHash string) (map[string]string, error) {
+	steps, err := readOrganizeLog()
+	if err != nil {
+		return nil, err
+	}
+	moved := make(map[string]string)
+	for _, step := range steps {
+		if step.InfoHash == infoHash && step.Mode != organizeHardlink {
+			moved[step.From] = step.To
+		}
+	}
+	// A file organized twice was moved from where the first batch put it.
+	for from, to := range moved {
+		for range len(moved) {
+			next, ok := moved[to]
+			if !ok {
+				break
+			}
+			to = next
+		}
+		moved[from] = to
+	}
+	return moved, nil
+}
+
+func (m *model) verifyLibraryItem(t Torrent) tea.Cmd {
+	if strings.HasPrefix(t.InfoHash, untrackedPrefix) {
+		m.notice = t.Name + " was imported by a rescan and has no torrent to verify against"
+		return nil
+	}
+	moved, err := movedFiles(t.InfoHash)
+	if err != nil {
+		m.notice = "Verify failed: " + err.Error()
+		return nil
+	}
+	return func() tea.Msg {
+		dir := t.Path
+		if dir == "" {
+			dir = t.dir()
+		}
+
+		metadata := metadataPath(dir, t.InfoHash)
+		if _, err := os.Stat(metadata); os.IsNotExist(err) {
+			log.Printf("No metadata for %s, fetching it", t.Name)
+			if err := fetchMetadata(t, dir); err != nil {
+				return verifyDoneMsg{infoHash: t.InfoHash, err: err}
+			}
+		}
+
+		meta, err := parseTorrentFile(metadata)
+		if err != nil {
+			return verifyDoneMsg{infoHash: t.InfoHash, err: err}
+		}
+		result := hashPieces(dir, meta, moved)
+		log.Printf("Verified %s: %d/%d pieces good", t.Name, result.Good, result.Total)
+		return verifyDoneMsg{infoHash: t.InfoHash, result: result, organized: len(moved) > 0}
+	}
+}
+
+func (m *model) verifyDone(msg verifyDoneMsg) {
+	t := m.findLibraryItem(msg.infoHash)
+	if t == nil {
+		return
+	}
+	if msg.err != nil {
+		m.notice = "Verify failed: " + msg.err.Error()
+		return
+	}
+
+	t.Verify = &msg.result
+	bad := msg.result.Total - msg.result.Good
+	if bad == 0 {
+		m.notice = fmt.Sprintf("%s: all %d pieces good", t.Name, msg.result.Total)
+		return
+	}
+
+	if msg.organized {
+		m.notice = fmt.Sprintf("%s: %.1f%% good, %d bad pieces. Undo organizing it to repair them", t.Name, msg.result.Percent(), bad)
+		return
+	}
+
+	infoHash := t.InfoHash
+	m.confirm = &confirmPrompt{
+		text: fmt.Sprintf("%s: %.1f%% good, %d bad pieces. Re-download them?", t.Name, msg.result.Percent(), bad),
+		yes: func() tea.Cmd {
+			return m.repairLibraryItem(infoHash)
+		},
+	}
+}
+
+// repairLibraryItem moves an item back to downloads so aria2 checks it
+// against the saved metadata and fetches only the bad pieces. A leftover
+// downloads entry for it is replaced, so the repair is the only one.
+func (m *model) repairLibraryItem(infoHash string) tea.Cmd {
+	t := m.findLibraryItem(infoHash)
+	if t == nil {
+		return nil
+	}
+	if d := m.findDownload(infoHash); d != nil {
+		switch d.DownloadStatus {
+		case "Stored", "Failed", "Errored", "Cancelled":
+		default:
+			m.notice = fmt.Sprintf("%s is already downloading", t.Name)
+			return nil
+		}
+	}
+
+	repair := *t
+	repair.DownloadStatus = "pending"
+	repair.Repair = true
+	repair.Attempts = 0
+	repair.PGID, repair.Port, repair.GID = 0, 0, ""
+	m.Downloading = append(withoutEntry(m.Downloading, infoHash), repair)
+	m.Library = withoutEntry(m.Library, infoHash)
+	m.UpdateTables()
+	return m.DownloadTorrents()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeBencode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  any
+		rest  string
+		ok    bool
+	}{
+		{"i42e", int64(42), "", true},
+		{"i-7eXY", int64(-7), "XY", true},
+		{"4:spam", "spam", "", true},
+		{"0:", "", "", true},
+		{"l4:spami1ee", []any{"spam", int64(1)}, "", true},
+		{"d3:cow3:moo4:spaml1:a1:bee", map[string]any{"cow": "moo", "spam": []any{"a", "b"}}, "", true},
+		{"de", map[string]any{}, "", true},
+		{"", nil, "", false},
+		{"i42", nil, "", false},
+		{"ixe", nil, "", false},
+		{"5:spam", nil, "", false},
+		{"-1:x", nil, "", false},
+		{"l4:spam", nil, "", false},
+		{"di1e3:fooe", nil, "", false},
+		{"d3:foo", nil, "", false},
+		{"x", nil, "", false},
+	}
+	for _, tt := range tests {
+		got, rest, err := decodeBencode([]byte(tt.input))
+		if (err == nil) != tt.ok {
+			t.Errorf("decodeBencode(%q) error = %v, want ok %v", tt.input, err, tt.ok)
+			continue
+		}
+		if tt.ok && (!reflect.DeepEqual(got, tt.want) || string(rest) != tt.rest) {
+			t.Errorf("decodeBencode(%q) = %#v, %q, want %#v, %q", tt.input, got, rest, tt.want, tt.rest)
+		}
+	}
+}
+
+func TestHashPieces(t *testing.T) {
+	// Two files of 6 and 5 bytes make three 4-byte pieces, the middle one
+	// spanning both files.
+	first, second := "abcdef", "ghijk"
+	data := first + second
+	meta := &torrentMeta{pieceLength: 4, files: []metaFile{
+		{path: filepath.Join("Show", "a.mkv"), length: int64(len(first))},
+		{path: filepath.Join("Show", "b.srt"), length: int64(len(second))},
+	}}
+	for i := 0; i < len(data); i += 4 {
+		sum := sha1.Sum([]byte(data[i:min(i+4, len(data))]))
+		meta.pieces = append(meta.pieces, string(sum[:]))
+	}
+
+	tests := []struct {
+		name     string
+		first    string
+		second   string
+		moveB    bool
+		wantGood int
+	}{
+		{"intact", first, second, false, 3},
+		{"corrupt start", "Xbcdef", second, false, 2},
+		{"corrupt across files", first, "Ghijk", false, 2},
+		{"short file", "abc", second, false, 1},
+		{"missing file", first, "", false, 1},
+		{"moved file", first, second, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := filepath.Join(dir, "Show", "a.mkv")
+			b := filepath.Join(dir, "Show", "b.srt")
+			files := map[string]string{a: tt.first}
+			moved := map[string]string{}
+			if tt.moveB {
+				to := filepath.Join(dir, "Organized", "b.srt")
+				moved[b] = to
+				b = to
+			}
+			if tt.second != "" {
+				files[b] = tt.second
+			}
+			for path, content := range files {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result := hashPieces(dir, meta, moved)
+			if result.Good != tt.wantGood || result.Total != 3 {
+				t.Errorf("hashPieces = %d/%d good, want %d/3", result.Good, result.Total, tt.wantGood)
+			}
+		})
+	}
+}
+
+func TestVerifyRefusesUntrackedItems(t *testing.T) {
+	m := New(DefaultSettings())
+	item := Torrent{InfoHash: untrackedPrefix + "Home Videos", Name: "Home Videos", Untracked: true}
+	m.Library = []Torrent{item}
+
+	if cmd := m.verifyLibraryItem(item); cmd != nil {
+		t.Error("verifying an untracked item went looking for its metadata")
+	}
+	if m.notice == "" {
+		t.Error("refusing to verify an untracked item said nothing")
+	}
+}